@@ -1,26 +1,106 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	rand "math/rand/v2"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/mr-karan/lil/internal/analytics"
+	"github.com/mr-karan/lil/internal/enrich"
 	"github.com/mr-karan/lil/internal/metrics"
+	"github.com/mr-karan/lil/internal/middleware"
+	"github.com/mr-karan/lil/internal/qr"
 	"github.com/mr-karan/lil/internal/store"
+	"github.com/mr-karan/lil/internal/targeting"
 	"github.com/mr-karan/lil/models"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// shortenURLRequest creates a link. The url of A/B links defaults to the first
+// of the variants.
 type shortenURLRequest struct {
-	URL          string `json:"url"`
-	Title        string `json:"title,omitempty"`
-	Slug         string `json:"slug,omitempty"`
-	ExpiryInSecs *int64 `json:"expiry_in_secs,omitempty"`
+	URL                string            `json:"url"`
+	Title              string            `json:"title,omitempty"`
+	Slug               string            `json:"slug,omitempty"`
+	ExpiryInSecs       *int64            `json:"expiry_in_secs,omitempty"`
+	RedirectType       int               `json:"redirect_type,omitempty"`
+	Password           string            `json:"password,omitempty"`
+	MaxClicks          int64             `json:"max_clicks,omitempty"`
+	ActiveFrom         *time.Time        `json:"active_from,omitempty"`
+	ExpiredRedirectURL string            `json:"expired_redirect_url,omitempty"`
+	Variants           []models.Variant  `json:"variants,omitempty"`
+	StickyVariants     bool              `json:"sticky_variants,omitempty"`
+	Rules              []models.Rule     `json:"rules,omitempty"`
+	ForwardQuery       bool              `json:"forward_query,omitempty"`
+	ForwardPath        bool              `json:"forward_path,omitempty"`
+	Interstitial       bool              `json:"interstitial,omitempty"`
+	UTM                *models.UTM       `json:"utm,omitempty"`
+	OG                 *models.OpenGraph `json:"og,omitempty"`
+}
+
+// updateURLRequest is a partial update; omitted fields are left unchanged.
+// An expiry_in_secs of 0 removes the expiry, an empty active_from the
+// activation time and an empty expired_redirect_url the fallback. Variants and
+// rules replace the existing ones, an empty list removes them. So does og
+// replace the preview card, an empty one removes it.
+type updateURLRequest struct {
+	URL                *string           `json:"url,omitempty"`
+	Title              *string           `json:"title,omitempty"`
+	ExpiryInSecs       *int64            `json:"expiry_in_secs,omitempty"`
+	RedirectType       *int              `json:"redirect_type,omitempty"`
+	Password           *string           `json:"password,omitempty"`
+	MaxClicks          *int64            `json:"max_clicks,omitempty"`
+	ActiveFrom         *string           `json:"active_from,omitempty"`
+	ExpiredRedirectURL *string           `json:"expired_redirect_url,omitempty"`
+	Variants           *[]models.Variant `json:"variants,omitempty"`
+	StickyVariants     *bool             `json:"sticky_variants,omitempty"`
+	Rules              *[]models.Rule    `json:"rules,omitempty"`
+	ForwardQuery       *bool             `json:"forward_query,omitempty"`
+	ForwardPath        *bool             `json:"forward_path,omitempty"`
+	Interstitial       *bool             `json:"interstitial,omitempty"`
+	OG                 *models.OpenGraph `json:"og,omitempty"`
+}
+
+// maxVariants caps the number of variants of an A/B link.
+const maxVariants = 10
+
+// variantCookie remembers the variant of sticky A/B links. It's scoped to the
+// path of the link, so there's one per link.
+const variantCookie = "lil_variant"
+
+// Responses to requests for links that aren't active yet, set with app.not_active_response.
+const (
+	notActiveNotFound = "not_found"
+	notActivePage     = "page"
+	notActiveRedirect = "redirect"
+)
+
+// maxListLimit caps the page size of cursor paginated listings.
+const maxListLimit = 1000
+
+// Suffixes of a short code that lead to the QR code of the link (e.g. /abc.qr)
+// and to its preview page (e.g. /abc+) instead. Slugs can't end with them.
+const (
+	qrSuffix      = ".qr"
+	previewSuffix = "+"
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
 }
 
 // httpResp represents the structure of the JSON response envelope
@@ -79,26 +159,99 @@ func (app *App) handleShortenURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateSlug(req.Slug); err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if err := normalizeVariants(req.Variants); err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if req.URL == "" && len(req.Variants) > 0 {
+		req.URL = req.Variants[0].URL
+	}
+	if err := app.validateRules(req.Rules); err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
 	// Basic validation
 	if req.URL == "" {
 		app.sendErrorResponse(w, "URL is required", http.StatusBadRequest, nil)
 		return
 	}
+	if req.RedirectType != 0 && !models.ValidRedirectType(req.RedirectType) {
+		app.sendErrorResponse(w, "redirect_type must be one of 301, 302, 307 or 308", http.StatusBadRequest, nil)
+		return
+	}
+	if req.MaxClicks < 0 {
+		app.sendErrorResponse(w, "max_clicks cannot be negative", http.StatusBadRequest, nil)
+		return
+	}
 
 	// Calculate expiry time if provided
 	var expiry time.Duration
 	if req.ExpiryInSecs != nil && *req.ExpiryInSecs > 0 {
 		expiry = time.Duration(*req.ExpiryInSecs) * time.Second
 	}
+	if req.ActiveFrom != nil && expiry > 0 && !req.ActiveFrom.Before(time.Now().Add(expiry)) {
+		app.sendErrorResponse(w, "active_from must be before the expiry", http.StatusBadRequest, nil)
+		return
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		app.logger.Error("Failed to hash password", "error", err)
+		app.sendErrorResponse(w, "Failed to create short URL", http.StatusInternalServerError, nil)
+		return
+	}
+
+	urlData := models.URLData{
+		URL:                req.URL,
+		Title:              req.Title,
+		ShortCode:          req.Slug,
+		RedirectType:       req.RedirectType,
+		PasswordHash:       passwordHash,
+		MaxClicks:          req.MaxClicks,
+		ActiveFrom:         req.ActiveFrom,
+		ExpiredRedirectURL: req.ExpiredRedirectURL,
+		Variants:           req.Variants,
+		StickyVariants:     req.StickyVariants,
+		Rules:              req.Rules,
+		ForwardQuery:       req.ForwardQuery,
+		ForwardPath:        req.ForwardPath,
+		Interstitial:       req.Interstitial,
+		UTM:                req.UTM,
+	}
+	if req.OG != nil && !req.OG.IsZero() {
+		if err := req.OG.Validate(); err != nil {
+			app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+		urlData.OG = req.OG
+	}
+	if err := app.checkTargets(&urlData.URL, urlData.Variants, urlData.Rules, &urlData.ExpiredRedirectURL); err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if err := addUTM(&urlData); err != nil {
+		app.sendErrorResponse(w, "Invalid URL", http.StatusBadRequest, nil)
+		return
+	}
 
 	// Call store method to create short URL
-	shortCode, err := app.store.CreateShortURL(context.TODO(), req.URL, req.Title, req.Slug, expiry)
+	shortCode, err := app.store.CreateShortURL(context.TODO(), urlData, expiry)
+	if err == store.ErrSlugExists {
+		app.sendErrorResponse(w, "Slug already exists", http.StatusConflict, nil)
+		return
+	}
 	if err != nil {
 		app.logger.Error("Failed to create short URL", "error", err, "url", req.URL)
 		metrics.URLsShortenedTotal.Inc()
 		app.sendErrorResponse(w, "Failed to create short URL", http.StatusInternalServerError, nil)
 		return
 	}
+	app.enrich(shortCode, urlData.URL)
 
 	// Return the shortened URL with public base URL
 	app.sendResponse(w, map[string]interface{}{
@@ -131,10 +284,221 @@ func (app *App) handleDeleteURL(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (app *App) handleUpdateURL(w http.ResponseWriter, r *http.Request) {
+	// Extract shortCode from path
+	shortCode := r.PathValue("shortCode")
+	if shortCode == "" {
+		app.sendErrorResponse(w, "Invalid short code", http.StatusBadRequest, nil)
+		return
+	}
+
+	var req updateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.logger.Error("Invalid request body", "error", err)
+		app.sendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.URL != nil && *req.URL == "" {
+		app.sendErrorResponse(w, "URL cannot be empty", http.StatusBadRequest, nil)
+		return
+	}
+	if req.RedirectType != nil && *req.RedirectType != 0 && !models.ValidRedirectType(*req.RedirectType) {
+		app.sendErrorResponse(w, "redirect_type must be one of 0, 301, 302, 307 or 308", http.StatusBadRequest, nil)
+		return
+	}
+	if req.MaxClicks != nil && *req.MaxClicks < 0 {
+		app.sendErrorResponse(w, "max_clicks cannot be negative", http.StatusBadRequest, nil)
+		return
+	}
+
+	upd := models.URLUpdate{
+		URL:                req.URL,
+		Title:              req.Title,
+		RedirectType:       req.RedirectType,
+		MaxClicks:          req.MaxClicks,
+		ExpiredRedirectURL: req.ExpiredRedirectURL,
+		Variants:           req.Variants,
+		StickyVariants:     req.StickyVariants,
+		Rules:              req.Rules,
+		ForwardQuery:       req.ForwardQuery,
+		ForwardPath:        req.ForwardPath,
+		Interstitial:       req.Interstitial,
+		OG:                 req.OG,
+	}
+	if req.OG != nil {
+		if err := req.OG.Validate(); err != nil {
+			app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if req.Rules != nil {
+		if err := app.validateRules(*req.Rules); err != nil {
+			app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if req.Variants != nil {
+		if err := normalizeVariants(*req.Variants); err != nil {
+			app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+		if req.URL == nil && len(*req.Variants) > 0 {
+			upd.URL = &(*req.Variants)[0].URL
+		}
+	}
+	var variants []models.Variant
+	if req.Variants != nil {
+		variants = *req.Variants
+	}
+	var rules []models.Rule
+	if req.Rules != nil {
+		rules = *req.Rules
+	}
+	if err := app.checkTargets(upd.URL, variants, rules, upd.ExpiredRedirectURL); err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	// New targets get the campaign parameters the link was created with
+	if upd.URL != nil || req.Variants != nil {
+		current, err := app.store.GetURL(r.Context(), shortCode)
+		if err == store.ErrNotExist {
+			app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			app.logger.Error("Failed to get URL", "error", err, "shortCode", shortCode)
+			app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+			return
+		}
+		if current.UTM != nil {
+			target := current.URL
+			if upd.URL != nil {
+				target = *upd.URL
+			}
+			// The variants are updated in place
+			withUTM := models.URLData{URL: target, Variants: variants, UTM: current.UTM}
+			if err := addUTM(&withUTM); err != nil {
+				app.sendErrorResponse(w, "Invalid URL", http.StatusBadRequest, nil)
+				return
+			}
+			if upd.URL != nil {
+				upd.URL = &withUTM.URL
+			}
+		}
+	}
+
+	if req.Password != nil {
+		passwordHash, err := hashPassword(*req.Password)
+		if err != nil {
+			app.logger.Error("Failed to hash password", "error", err)
+			app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+			return
+		}
+		upd.PasswordHash = &passwordHash
+	}
+	if req.ExpiryInSecs != nil {
+		switch {
+		case *req.ExpiryInSecs < 0:
+			app.sendErrorResponse(w, "expiry_in_secs cannot be negative", http.StatusBadRequest, nil)
+			return
+		case *req.ExpiryInSecs == 0:
+			upd.ClearExpiry = true
+		default:
+			t := time.Now().Add(time.Duration(*req.ExpiryInSecs) * time.Second)
+			upd.ExpiresAt = &t
+		}
+	}
+
+	if req.ActiveFrom != nil {
+		if *req.ActiveFrom == "" {
+			upd.ClearActiveFrom = true
+		} else {
+			t, err := time.Parse(time.RFC3339, *req.ActiveFrom)
+			if err != nil {
+				app.sendErrorResponse(w, "active_from must be an RFC3339 timestamp", http.StatusBadRequest, nil)
+				return
+			}
+			upd.ActiveFrom = &t
+		}
+	}
+
+	// Check the new active_from or expiry against the other one, which may be
+	// the one stored
+	if upd.ActiveFrom != nil || upd.ExpiresAt != nil {
+		activeFrom, expiresAt := upd.ActiveFrom, upd.ExpiresAt
+		if req.ActiveFrom == nil || req.ExpiryInSecs == nil {
+			current, err := app.store.GetURL(r.Context(), shortCode)
+			if err == store.ErrNotExist {
+				app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
+				return
+			}
+			if err != nil {
+				app.logger.Error("Failed to get URL", "error", err, "shortCode", shortCode)
+				app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+				return
+			}
+			if req.ActiveFrom == nil {
+				activeFrom = current.ActiveFrom
+			}
+			if req.ExpiryInSecs == nil {
+				expiresAt = current.ExpiresAt
+			}
+		}
+		if activeFrom != nil && expiresAt != nil && !activeFrom.Before(*expiresAt) {
+			app.sendErrorResponse(w, "active_from must be before the expiry", http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	// The description and favicon of the old target are fetched again for the
+	// new one, which only fills in empty ones
+	if upd.URL != nil && app.enricher != nil {
+		var empty string
+		upd.Description, upd.Favicon = &empty, &empty
+	}
+
+	urlData, err := app.store.UpdateURL(r.Context(), shortCode, upd)
+	if err != nil {
+		if err == store.ErrNotExist {
+			app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
+			return
+		}
+		app.logger.Error("Failed to update URL", "error", err, "shortCode", shortCode)
+		app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+	if upd.URL != nil {
+		app.enrich(shortCode, urlData.URL)
+	}
+
+	app.sendResponse(w, urlData)
+}
+
 func (app *App) handleGetURLs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	q := models.URLQuery{
+		Search:   params.Get("search"),
+		Campaign: params.Get("campaign"),
+		Sort:     models.SortCreatedAt,
+		Desc:     true,
+	}
+	if err := parseURLFilters(r, &q); err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	// Use cursor pagination if a cursor or limit is passed, page numbers otherwise
+	if params.Has("cursor") || params.Has("limit") {
+		app.getURLsByCursor(w, r, q)
+		return
+	}
+
 	// Get pagination parameters from query string
-	page := r.URL.Query().Get("page")
-	perPage := r.URL.Query().Get("per_page")
+	page := params.Get("page")
+	perPage := params.Get("per_page")
 
 	// Convert to int64 with defaults
 	pageNum := int64(1)
@@ -151,8 +515,11 @@ func (app *App) handleGetURLs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	q.Page = pageNum
+	q.PerPage = perPageNum
+
 	// Fetch URLs from store
-	urls, total, err := app.store.GetURLs(context.TODO(), pageNum, perPageNum)
+	res, err := app.store.GetURLs(r.Context(), q)
 	if err != nil {
 		app.logger.Error("Failed to fetch URLs", "error", err)
 		app.sendErrorResponse(w, "Failed to fetch URLs", http.StatusInternalServerError, nil)
@@ -161,14 +528,160 @@ func (app *App) handleGetURLs(w http.ResponseWriter, r *http.Request) {
 
 	// Return the URLs
 	app.sendResponse(w, map[string]interface{}{
-		"urls":     urls,
+		"urls":     res.URLs,
 		"page":     pageNum,
 		"per_page": perPageNum,
-		"count":    total,
+		"count":    *res.Total,
 	})
 }
 
-func (app *App) handleRedirect(w http.ResponseWriter, r *http.Request) {
+// getURLsByCursor serves the URL listing with keyset pagination. Unlike page
+// numbers, this doesn't need to skip over previous pages, and the total count
+// is only computed when asked for.
+func (app *App) getURLsByCursor(w http.ResponseWriter, r *http.Request, q models.URLQuery) {
+	params := r.URL.Query()
+
+	limit := int64(10)
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || l < 1 || l > maxListLimit {
+			app.sendErrorResponse(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest, nil)
+			return
+		}
+		limit = l
+	}
+
+	q.Keyset = true
+	q.Cursor = params.Get("cursor")
+	q.PerPage = limit
+	if v := params.Get("count"); v != "" {
+		withCount, err := strconv.ParseBool(v)
+		if err != nil {
+			app.sendErrorResponse(w, "invalid count: "+v, http.StatusBadRequest, nil)
+			return
+		}
+		q.WithCount = withCount
+	}
+
+	res, err := app.store.GetURLs(r.Context(), q)
+	if err != nil {
+		if err == store.ErrInvalidCursor {
+			app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+		app.logger.Error("Failed to fetch URLs", "error", err)
+		app.sendErrorResponse(w, "Failed to fetch URLs", http.StatusInternalServerError, nil)
+		return
+	}
+
+	out := map[string]interface{}{
+		"urls":        res.URLs,
+		"limit":       limit,
+		"next_cursor": nil,
+	}
+	if res.NextCursor != "" {
+		out["next_cursor"] = res.NextCursor
+	}
+	if res.Total != nil {
+		out["count"] = *res.Total
+	}
+	app.sendResponse(w, out)
+}
+
+// parseURLFilters reads the filter and sort query parameters of the URL listing into q.
+func parseURLFilters(r *http.Request, q *models.URLQuery) error {
+	params := r.URL.Query()
+
+	for key, dst := range map[string]*bool{
+		"expired":   &q.Expired,
+		"scheduled": &q.Scheduled,
+		"variants":  &q.WithVariants,
+	} {
+		v := params.Get(key)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", key, v)
+		}
+		*dst = b
+	}
+	if q.Expired && q.Scheduled {
+		return fmt.Errorf("expired and scheduled can't be combined")
+	}
+
+	for key, dst := range map[string]**time.Time{
+		"expiring_before": &q.ExpiringBefore,
+		"created_after":   &q.CreatedAfter,
+	} {
+		v := params.Get(key)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("invalid %s, expected an RFC3339 timestamp: %s", key, v)
+		}
+		*dst = &t
+	}
+
+	if v := params.Get("sort"); v != "" {
+		switch v {
+		case models.SortCreatedAt, models.SortClicks, models.SortTitle:
+			q.Sort = v
+		default:
+			return fmt.Errorf("invalid sort: %s", v)
+		}
+	}
+
+	switch params.Get("order") {
+	case "":
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return fmt.Errorf("invalid order: %s", params.Get("order"))
+	}
+
+	return nil
+}
+
+func (app *App) handleGetStats(w http.ResponseWriter, r *http.Request) {
+	// Extract shortCode from path
+	shortCode := r.PathValue("shortCode")
+	if shortCode == "" {
+		app.sendErrorResponse(w, "Invalid short code", http.StatusBadRequest, nil)
+		return
+	}
+
+	// Number of daily buckets to return
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > 365 {
+			app.sendErrorResponse(w, "days must be between 1 and 365", http.StatusBadRequest, nil)
+			return
+		}
+		days = n
+	}
+
+	stats, err := app.store.GetStats(r.Context(), shortCode, days)
+	if err != nil {
+		if err == store.ErrNotExist {
+			app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
+			return
+		}
+		app.logger.Error("Failed to fetch stats", "error", err, "shortCode", shortCode)
+		app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+
+	app.sendResponse(w, stats)
+}
+
+func (app *App) handleGetQR(w http.ResponseWriter, r *http.Request) {
 	// Extract shortCode from path
 	shortCode := r.PathValue("shortCode")
 	if shortCode == "" {
@@ -176,20 +689,294 @@ func (app *App) handleRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.serveQR(w, r, shortCode, "private")
+}
+
+// serveQR renders the QR code of the public URL of a link, as set by the query
+// parameters. Links that aren't active yet have one too, so that it can be
+// printed ahead of time. The image only changes with the options, so it's
+// cached for app.qr_max_age with the given cache scope.
+func (app *App) serveQR(w http.ResponseWriter, r *http.Request, shortCode, scope string) {
+	opts, err := qr.ParseOptions(r.URL.Query())
+	if err != nil {
+		app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	if _, err := app.store.GetURL(r.Context(), shortCode); err != nil {
+		if err == store.ErrNotExist {
+			app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
+			return
+		}
+		app.logger.Error("Failed to get URL data", "error", err, "shortCode", shortCode)
+		app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+
+	img, err := qr.Render(strings.TrimSuffix(ko.String("app.public_url"), "/")+"/"+shortCode, opts)
+	if err != nil {
+		if errors.Is(err, qr.ErrTooSmall) {
+			app.sendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+		app.logger.Error("Failed to render QR code", "error", err, "shortCode", shortCode)
+		app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+	metrics.QRCodesRenderedTotal.Inc()
+
+	sum := sha256.Sum256(img)
+	maxAge := durationOr("app.qr_max_age", 24*time.Hour)
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int64(maxAge.Seconds())))
+	// Answers conditional requests with a 304
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img))
+}
+
+func (app *App) handleRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("rest") == "" {
+		if shortCode, ok := strings.CutSuffix(r.PathValue("shortCode"), qrSuffix); ok {
+			app.serveQR(w, r, shortCode, "public")
+			return
+		}
+		if shortCode, ok := strings.CutSuffix(r.PathValue("shortCode"), previewSuffix); ok {
+			r.SetPathValue("shortCode", shortCode)
+			app.handlePreview(w, r)
+			return
+		}
+	}
+
+	urlData, ok := app.getRedirectData(w, r)
+	if !ok {
+		return
+	}
+
+	// Social network crawlers get the preview card of the link, if it has one,
+	// so that it unfurls with it instead of the card of the target
+	if urlData.OG != nil {
+		w.Header().Add("Vary", "User-Agent")
+		if targeting.SocialCrawler(r.UserAgent()) {
+			app.renderCard(w, urlData)
+			return
+		}
+	}
+
+	// Ask for the password instead of redirecting
+	if urlData.PasswordHash != "" {
+		app.renderPasswordForm(w, urlData.ShortCode, "", http.StatusOK)
+		return
+	}
+
+	// Have the visitor confirm the target first. The page posts back to the
+	// same URL, which redirects like an unlocked protected link. HEAD requests
+	// and bots, like link unfurlers and mail scanners, get it instead of the
+	// redirect of click-limited links, as every redirect uses up a click and
+	// they'd burn single-use links before the recipient gets to open them.
+	if urlData.Interstitial || urlData.MaxClicks > 0 && (r.Method == http.MethodHead || targeting.Bot(r.UserAgent())) {
+		app.renderPreview(w, r, urlData, forwardRequest(urlData.URL, r, urlData), r.URL.EscapedPath())
+		return
+	}
+
+	app.redirect(w, r, urlData, redirectType(urlData))
+}
+
+// handlePreview shows where a link goes instead of following it. Continuing
+// from the page follows the link as usual, so the destination of protected
+// links is only shown once they're unlocked.
+func (app *App) handlePreview(w http.ResponseWriter, r *http.Request) {
+	urlData, ok := app.getRedirectData(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderPreview(w, r, urlData, urlData.URL, "/"+urlData.ShortCode)
+}
+
+// handleUnlock checks the password submitted for a protected link and redirects
+// to it if it matches. Links without a password are redirected to right away,
+// which is how the continue button of preview pages follows them.
+func (app *App) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	urlData, ok := app.getRedirectData(w, r)
+	if !ok {
+		return
+	}
+
+	if urlData.PasswordHash != "" {
+		// Every attempt counts until it turns out to be the right password
+		ok, reset := app.passwords.Reserve(r)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(reset).Seconds()+1))))
+			app.renderPasswordForm(w, urlData.ShortCode, "Too many incorrect passwords, try again later", http.StatusTooManyRequests)
+			return
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(urlData.PasswordHash), []byte(r.PostFormValue("password")))
+		if err != nil {
+			metrics.PasswordFailuresTotal.Inc()
+			app.renderPasswordForm(w, urlData.ShortCode, "Incorrect password", http.StatusUnauthorized)
+			return
+		}
+		app.passwords.Release(r, reset)
+	}
+
+	// Always answer the form with a 303 so that the browser follows it with a GET
+	// instead of re-posting the password to the target, as it would with a 307/308
+	app.redirect(w, r, urlData, http.StatusSeeOther)
+}
+
+// getRedirectData looks up the URL of the short code in the path, writing the
+// error response if there's none.
+func (app *App) getRedirectData(w http.ResponseWriter, r *http.Request) (models.URLData, bool) {
+	// Extract shortCode from path
+	shortCode := r.PathValue("shortCode")
+	if shortCode == "" {
+		app.sendErrorResponse(w, "Invalid short code", http.StatusBadRequest, nil)
+		return models.URLData{}, false
+	}
+
 	// Get URL data from store
 	urlData, err := app.store.GetRedirectData(context.TODO(), shortCode)
 	if err != nil {
 		if err == store.ErrNotExist {
 			metrics.RedirectFailuresTotal.Inc()
-			app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
-			return
+			app.sendNotFound(w, r)
+			return models.URLData{}, false
+		}
+		if err == store.ErrExpired {
+			metrics.RedirectFailuresTotal.Inc()
+			app.redirectFallback(w, r, urlData.ExpiredRedirectURL)
+			return models.URLData{}, false
+		}
+		if err == store.ErrNotActive {
+			app.sendNotActive(w, r)
+			return models.URLData{}, false
 		}
 		app.logger.Error("Failed to get URL data", "error", err, "shortCode", shortCode)
 		app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+		return models.URLData{}, false
+	}
+
+	// Only links that forward the path can be followed with one
+	if r.PathValue("rest") != "" && !urlData.ForwardPath {
+		metrics.RedirectFailuresTotal.Inc()
+		app.sendNotFound(w, r)
+		return models.URLData{}, false
+	}
+
+	return urlData, true
+}
+
+// sendNotActive answers a request for a link that's scheduled to become active
+// later, as configured by app.not_active_response. The response is never cached
+// so that the link starts working as soon as it's active.
+func (app *App) sendNotActive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch ko.String("app.not_active_response") {
+	case notActivePage:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		if err := pages.ExecuteTemplate(w, "scheduled.html", nil); err != nil {
+			app.logger.Error("Failed to render scheduled page", "error", err)
+		}
+	case notActiveRedirect:
+		http.Redirect(w, r, ko.String("app.not_active_url"), http.StatusFound)
+	default:
+		app.sendNotFound(w, r)
+	}
+}
+
+// sendNotFound answers a request for a dead link. API clients get the JSON
+// error, browsers are redirected to app.fallback_url if it's set and are shown
+// the 404 page otherwise.
+func (app *App) sendNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if !acceptsHTML(r) {
+		app.sendErrorResponse(w, "URL not found", http.StatusNotFound, nil)
 		return
 	}
+	if fallback := ko.String("app.fallback_url"); fallback != "" {
+		app.redirectFallback(w, r, fallback)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNotFound)
+	err := app.notFoundPage.Execute(w, map[string]string{
+		"ShortCode": r.PathValue("shortCode"),
+	})
+	if err != nil {
+		app.logger.Error("Failed to render not found page", "error", err)
+	}
+}
+
+// redirectFallback sends the visitor of a dead link to a fallback URL. The
+// redirect isn't cached, as the link may be recreated or updated later.
+func (app *App) redirectFallback(w http.ResponseWriter, r *http.Request, target string) {
+	metrics.FallbackRedirectsTotal.Inc()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// acceptsHTML reports whether the client prefers HTML over JSON, going by
+// which of the two comes first in its Accept header.
+func acceptsHTML(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/html":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// redirect records a click on the URL and redirects to it with the given status.
+func (app *App) redirect(w http.ResponseWriter, r *http.Request, urlData models.URLData, status int) {
+	shortCode := urlData.ShortCode
+
+	// Use up one of the clicks of a click-limited link. Another request may have
+	// taken the last one since the link was looked up.
+	if urlData.MaxClicks > 0 {
+		if _, err := app.store.ConsumeClick(r.Context(), shortCode); err != nil {
+			if err == store.ErrNotExist {
+				metrics.RedirectFailuresTotal.Inc()
+				if urlData.ExpiredRedirectURL != "" {
+					app.redirectFallback(w, r, urlData.ExpiredRedirectURL)
+				} else {
+					app.sendNotFound(w, r)
+				}
+				return
+			}
+			app.logger.Error("Failed to consume click", "error", err, "shortCode", shortCode)
+			app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	// Send visitors matching a targeting rule to its target, and the others of
+	// A/B links to one of the variants
+	target, variant := urlData.URL, ""
+	client := targeting.FromRequest(r)
+	if app.geo != nil {
+		client.Country = app.geo.Country(app.proxies.ClientIP(r))
+	}
+	if len(urlData.Rules) > 0 {
+		w.Header().Add("Vary", "User-Agent, Accept-Language")
+	}
+	if rule, ok := targeting.Match(urlData.Rules, client); ok {
+		target = rule.URL
+	} else if len(urlData.Variants) > 0 {
+		v := app.chooseVariant(w, r, urlData)
+		target, variant = v.URL, v.Name
+	}
+	target = forwardRequest(target, r, urlData)
 
 	metrics.RedirectsTotal.Inc()
+	app.store.RecordClick(shortCode, time.Now())
 	if app.analytics != nil {
 		app.analytics.Track(analytics.Event{
 			Name:       "pageview",
@@ -200,16 +987,376 @@ func (app *App) handleRedirect(w http.ResponseWriter, r *http.Request) {
 			RemoteAddr: r.RemoteAddr,
 			Timestamp:  time.Now().UTC().Format(time.RFC3339),
 			ShortCode:  shortCode,
-			TargetURL:  urlData.URL,
+			TargetURL:  target,
+			Variant:    variant,
+			Country:    client.Country,
 		})
 	}
 
-	// Ensure browsers don't cache the redirect response to prevent stale redirects
-	// if the target URL is updated or the short link expires
-	w.Header().Set("Cache-Control", "public, max-age=0, must-revalidate")
+	switch {
+	case urlData.MaxClicks > 0 || len(urlData.Variants) > 0 || targeting.UsesCountries(urlData.Rules) ||
+		status == http.StatusSeeOther:
+		// Every click on a click-limited or A/B link has to reach us to be counted
+		// (and rotated), the target of geo-targeted links depends on the client IP,
+		// and a 303 is only sent after unlocking a protected link, so never cache these
+		w.Header().Set("Cache-Control", "no-store")
+	case status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect:
+		// Permanent redirects are cached, but only for a while (and never past
+		// the expiry) so that an updated target still gets picked up eventually
+		maxAge := durationOr("app.permanent_redirect_max_age", 24*time.Hour)
+		if urlData.ExpiresAt != nil {
+			maxAge = min(maxAge, time.Until(*urlData.ExpiresAt))
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(maxAge.Seconds())))
+	default:
+		// Ensure browsers don't cache the redirect response to prevent stale redirects
+		// if the target URL is updated or the short link expires
+		w.Header().Set("Cache-Control", "public, max-age=0, must-revalidate")
+	}
+
+	w.Header().Set("Location", target)
+	w.WriteHeader(status)
+}
+
+// checkTargets validates and normalizes the target URLs of a link in place: its
+// url, those of its variants and rules, and its expired_redirect_url. Nil and
+// empty optional ones are skipped. The error names the offending field.
+func (app *App) checkTargets(target *string, variants []models.Variant, rules []models.Rule, expiredRedirectURL *string) error {
+	check := func(field string, u *string) error {
+		normalized, err := app.validator.Check(*u)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		*u = normalized
+		return nil
+	}
+
+	if target != nil {
+		if err := check("url", target); err != nil {
+			return err
+		}
+	}
+	for i := range variants {
+		if err := check("variant "+variants[i].Name, &variants[i].URL); err != nil {
+			return err
+		}
+	}
+	for i := range rules {
+		if err := check(fmt.Sprintf("rule %d", i+1), &rules[i].URL); err != nil {
+			return err
+		}
+	}
+	if expiredRedirectURL != nil && *expiredRedirectURL != "" {
+		if err := check("expired_redirect_url", expiredRedirectURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enrich schedules the title, description and favicon of a link to be fetched
+// from its target, if enabled.
+func (app *App) enrich(shortCode, target string) {
+	if app.enricher != nil {
+		app.enricher.Enqueue(shortCode, target)
+	}
+}
+
+// storeMetadata saves what was fetched from the target of a link. Only the
+// fields the link doesn't have yet are filled in, so that it never overwrites
+// ones set by the user.
+func (app *App) storeMetadata(ctx context.Context, shortCode string, md enrich.Metadata) error {
+	return app.store.FillMetadata(ctx, shortCode, md.Title, md.Description, md.Favicon)
+}
+
+// addUTM adds the UTM parameters of a new link to its URL and the URLs of its
+// variants.
+func addUTM(urlData *models.URLData) error {
+	if urlData.UTM == nil || urlData.UTM.IsZero() {
+		urlData.UTM = nil
+		return nil
+	}
+
+	target, err := urlData.UTM.AddTo(urlData.URL)
+	if err != nil {
+		return err
+	}
+	urlData.URL = target
+
+	for i, v := range urlData.Variants {
+		target, err := urlData.UTM.AddTo(v.URL)
+		if err != nil {
+			return err
+		}
+		urlData.Variants[i].URL = target
+	}
+	return nil
+}
+
+// forwardRequest adds the path after the short code and the query parameters
+// of the request to the target, if the link forwards them. Parameters the
+// target already has are left as they are.
+func forwardRequest(target string, r *http.Request, urlData models.URLData) string {
+	rest := r.PathValue("rest")
+	forwardPath := urlData.ForwardPath && rest != ""
+	forwardQuery := urlData.ForwardQuery && r.URL.RawQuery != ""
+	if !forwardPath && !forwardQuery {
+		return target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	// The path is cleaned as a rooted one first so that it can't climb above
+	// the target's path
+	if forwardPath {
+		clean := path.Clean("/" + rest)
+		if strings.HasSuffix(rest, "/") && clean != "/" {
+			clean += "/"
+		}
+		u = u.JoinPath(clean)
+	}
+	if forwardQuery {
+		q := u.Query()
+		for key, values := range r.URL.Query() {
+			if !q.Has(key) {
+				q[key] = values
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
+
+// validateRules checks the targeting rules of a link. Country conditions can
+// only be used with a geoip database.
+func (app *App) validateRules(rules []models.Rule) error {
+	if err := targeting.Validate(rules); err != nil {
+		return err
+	}
+	if app.geo == nil && targeting.UsesCountries(rules) {
+		return errors.New("countries conditions need a geoip database, see geoip.database")
+	}
+	return nil
+}
+
+// chooseVariant picks the variant of an A/B link to send the visitor to.
+// Returning visitors of sticky links get the variant they got the first time.
+func (app *App) chooseVariant(w http.ResponseWriter, r *http.Request, urlData models.URLData) models.Variant {
+	if !urlData.StickyVariants {
+		return pickVariant(urlData.Variants)
+	}
+
+	if c, err := r.Cookie(variantCookie); err == nil {
+		if v, ok := urlData.Variant(c.Value); ok && v.Weight > 0 {
+			return v
+		}
+	}
+
+	v := pickVariant(urlData.Variants)
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie,
+		Value:    v.Name,
+		Path:     "/" + urlData.ShortCode,
+		MaxAge:   int(durationOr("app.variant_cookie_max_age", 30*24*time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return v
+}
+
+// pickVariant picks a variant at random, in proportion to the weights.
+func pickVariant(variants []models.Variant) models.Variant {
+	var total int
+	for _, v := range variants {
+		total += v.Weight
+	}
+
+	n := rand.IntN(total)
+	for _, v := range variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
+
+// normalizeVariants validates the variants of an A/B link and names the
+// unnamed ones after their position: A, B, C and so on.
+func normalizeVariants(variants []models.Variant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+	if len(variants) > maxVariants {
+		return fmt.Errorf("at most %d variants are allowed", maxVariants)
+	}
+
+	var total int
+	names := make(map[string]bool, len(variants))
+	for i := range variants {
+		v := &variants[i]
+		if v.Name == "" {
+			v.Name = string(rune('A' + i))
+		}
+		if !validVariantName(v.Name) {
+			return fmt.Errorf("invalid variant name %q, use letters, digits, - and _", v.Name)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate variant name %q", v.Name)
+		}
+		names[v.Name] = true
+
+		if v.URL == "" {
+			return fmt.Errorf("variant %s has no url", v.Name)
+		}
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %s has a negative weight", v.Name)
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return errors.New("at least one variant needs a positive weight")
+	}
+
+	return nil
+}
+
+// validVariantName reports whether a variant name can be stored in a cookie as is.
+func validVariantName(name string) bool {
+	if len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSlug checks that a custom slug doesn't end with one of the suffixes
+// of the QR code and preview routes, which would make it unreachable.
+func validateSlug(slug string) error {
+	for _, suffix := range []string{qrSuffix, previewSuffix} {
+		if strings.HasSuffix(slug, suffix) {
+			return fmt.Errorf("slug cannot end with %s", suffix)
+		}
+	}
+	return nil
+}
+
+// hashPassword returns the bcrypt hash of a link password, or "" for no password.
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// renderPasswordForm serves the password form of a protected link.
+func (app *App) renderPasswordForm(w http.ResponseWriter, shortCode, errMsg string, code int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	err := pages.ExecuteTemplate(w, "password.html", map[string]string{
+		"ShortCode": shortCode,
+		"Error":     errMsg,
+	})
+	if err != nil {
+		app.logger.Error("Failed to render password form", "error", err)
+	}
+}
+
+// renderPreview renders the preview page of a link going to target. Its
+// continue button follows the link at the path link, keeping the query of the
+// request so that links forwarding it still get it.
+func (app *App) renderPreview(w http.ResponseWriter, r *http.Request, urlData models.URLData, target, link string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.URL.RawQuery != "" {
+		link += "?" + r.URL.RawQuery
+	}
+
+	err := pages.ExecuteTemplate(w, "preview.html", map[string]interface{}{
+		"Title":     urlData.Title,
+		"URL":       target,
+		"CreatedAt": urlData.CreatedAt,
+		"Protected": urlData.PasswordHash != "",
+		"Link":      link,
+	})
+	if err != nil {
+		app.logger.Error("Failed to render preview page", "error", err)
+	}
+}
+
+// renderCard renders a page with the OpenGraph and Twitter card tags of a
+// link. The title falls back to the title of the link.
+func (app *App) renderCard(w http.ResponseWriter, urlData models.URLData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	title := urlData.OG.Title
+	if title == "" {
+		title = urlData.Title
+	}
+	err := pages.ExecuteTemplate(w, "card.html", map[string]string{
+		"Title":       title,
+		"Description": urlData.OG.Description,
+		"Image":       urlData.OG.Image,
+		"URL":         strings.TrimSuffix(ko.String("app.public_url"), "/") + "/" + urlData.ShortCode,
+	})
+	if err != nil {
+		app.logger.Error("Failed to render card", "error", err)
+	}
+}
+
+// redirectType returns the HTTP status to redirect to a URL with.
+func redirectType(urlData models.URLData) int {
+	if urlData.RedirectType != 0 {
+		return urlData.RedirectType
+	}
+	if t := ko.Int("app.default_redirect_type"); t != 0 {
+		return t
+	}
+	return http.StatusFound
+}
+
+// csvLegacyColumns is the column order of bulk CSV files without a recognised header.
+var csvLegacyColumns = []string{"url", "title", "slug", "expiry"}
+
+// csvColumns maps the column names of a bulk CSV header to their index. Files
+// whose header doesn't name a url column are read in the legacy column order.
+func csvColumns(header []string) map[string]int {
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "expiry_in_secs" {
+			name = "expiry"
+		}
+		if _, ok := cols[name]; !ok {
+			cols[name] = i
+		}
+	}
+	if _, ok := cols["url"]; ok {
+		return cols
+	}
 
-	w.Header().Set("Location", urlData.URL)
-	w.WriteHeader(http.StatusFound)
+	cols = make(map[string]int, len(csvLegacyColumns))
+	for i, name := range csvLegacyColumns {
+		cols[name] = i
+	}
+	return cols
 }
 
 func (app *App) handleBulkUpload(w http.ResponseWriter, r *http.Request) {
@@ -227,11 +1374,17 @@ func (app *App) handleBulkUpload(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Trailing optional columns may be left out
 	records, err := reader.ReadAll()
 	if err != nil {
 		http.Error(w, "Unable to read CSV file", http.StatusInternalServerError)
 		return
 	}
+	if len(records) == 0 {
+		http.Error(w, "CSV file is empty", http.StatusBadRequest)
+		return
+	}
+	cols := csvColumns(records[0])
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -242,25 +1395,32 @@ func (app *App) handleBulkUpload(w http.ResponseWriter, r *http.Request) {
 	processBatch := func(batch []models.URLData) {
 		defer wg.Done()
 		shortenedURLs := app.store.CreateShortURLs(context.TODO(), batch)
+		for _, res := range shortenedURLs {
+			if shortCode, ok := res["shortUrl"]; ok {
+				app.enrich(shortCode, res["url"])
+			}
+		}
 		mu.Lock()
 		results = append(results, shortenedURLs...)
 		mu.Unlock()
 	}
 
-	// Start loop from index 1 to skip the first entry
+	// Start loop from index 1 to skip the header
+rows:
 	for i := 1; i < len(records); i++ {
 		record := records[i]
 		if len(record) == 0 {
 			continue
 		}
-
-		longURL := record[0]
-		title := record[1]
-		slug := record[2]
-		expiry := record[3]
+		field := func(name string) string {
+			if idx, ok := cols[name]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
 
 		var expiresAt *time.Time
-		if expiry != "" {
+		if expiry := field("expiry"); expiry != "" {
 			if expirySeconds, err := strconv.ParseInt(expiry, 10, 64); err == nil {
 				expiration := time.Now().Add(time.Duration(expirySeconds) * time.Second)
 				expiresAt = &expiration
@@ -268,13 +1428,154 @@ func (app *App) handleBulkUpload(w http.ResponseWriter, r *http.Request) {
 		}
 
 		urlData := models.URLData{
-			URL:       longURL,
-			Title:     title,
-			ShortCode: slug,
+			URL:       field("url"),
+			Title:     field("title"),
+			ShortCode: field("slug"),
 			CreatedAt: time.Now(),
 			ExpiresAt: expiresAt,
 		}
 
+		if v := field("redirect_type"); v != "" {
+			t, err := strconv.Atoi(v)
+			if err != nil || !models.ValidRedirectType(t) {
+				mu.Lock()
+				results = append(results, map[string]string{
+					"url":   urlData.URL,
+					"error": "invalid redirect_type",
+				})
+				mu.Unlock()
+				continue
+			}
+			urlData.RedirectType = t
+		}
+
+		if err := validateSlug(urlData.ShortCode); err != nil {
+			mu.Lock()
+			results = append(results, map[string]string{
+				"url":   urlData.URL,
+				"error": err.Error(),
+			})
+			mu.Unlock()
+			continue
+		}
+
+		urlData.ExpiredRedirectURL = field("expired_redirect_url")
+
+		if v := field("active_from"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				mu.Lock()
+				results = append(results, map[string]string{
+					"url":   urlData.URL,
+					"error": "invalid active_from",
+				})
+				mu.Unlock()
+				continue
+			}
+			if expiresAt != nil && !t.Before(*expiresAt) {
+				mu.Lock()
+				results = append(results, map[string]string{
+					"url":   urlData.URL,
+					"error": "active_from must be before the expiry",
+				})
+				mu.Unlock()
+				continue
+			}
+			urlData.ActiveFrom = &t
+		}
+
+		for name, dst := range map[string]*bool{
+			"forward_query": &urlData.ForwardQuery,
+			"forward_path":  &urlData.ForwardPath,
+			"interstitial":  &urlData.Interstitial,
+		} {
+			if v := field(name); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					mu.Lock()
+					results = append(results, map[string]string{
+						"url":   urlData.URL,
+						"error": "invalid " + name,
+					})
+					mu.Unlock()
+					continue rows
+				}
+				*dst = b
+			}
+		}
+
+		if v := field("max_clicks"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				mu.Lock()
+				results = append(results, map[string]string{
+					"url":   urlData.URL,
+					"error": "invalid max_clicks",
+				})
+				mu.Unlock()
+				continue
+			}
+			urlData.MaxClicks = n
+		}
+
+		passwordHash, err := hashPassword(field("password"))
+		if err != nil {
+			app.logger.Error("Failed to hash password", "error", err)
+			mu.Lock()
+			results = append(results, map[string]string{
+				"url":   urlData.URL,
+				"error": "failed to save url",
+			})
+			mu.Unlock()
+			continue
+		}
+		urlData.PasswordHash = passwordHash
+
+		if err := app.checkTargets(&urlData.URL, nil, nil, &urlData.ExpiredRedirectURL); err != nil {
+			mu.Lock()
+			results = append(results, map[string]string{
+				"url":   urlData.URL,
+				"error": err.Error(),
+			})
+			mu.Unlock()
+			continue
+		}
+
+		urlData.UTM = &models.UTM{
+			Source:   field("utm_source"),
+			Medium:   field("utm_medium"),
+			Campaign: field("utm_campaign"),
+			Term:     field("utm_term"),
+			Content:  field("utm_content"),
+		}
+		if err := addUTM(&urlData); err != nil {
+			mu.Lock()
+			results = append(results, map[string]string{
+				"url":   urlData.URL,
+				"error": "invalid url",
+			})
+			mu.Unlock()
+			continue
+		}
+
+		og := models.OpenGraph{
+			Title:       field("og_title"),
+			Description: field("og_description"),
+			Image:       field("og_image"),
+		}
+		if !og.IsZero() {
+			if err := og.Validate(); err != nil {
+				mu.Lock()
+				results = append(results, map[string]string{
+					"url":   urlData.URL,
+					"error": err.Error(),
+				})
+				mu.Unlock()
+				continue
+			}
+			urlData.OG = &og
+		}
+
 		batch = append(batch, urlData)
 
 		if len(batch) == batchSize {
@@ -297,3 +1598,84 @@ func (app *App) handleBulkUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 	}
 }
+
+func (app *App) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.logger.Error("Invalid request body", "error", err)
+		app.sendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.Name == "" {
+		app.sendErrorResponse(w, "Name is required", http.StatusBadRequest, nil)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		app.sendErrorResponse(w, "At least one scope is required", http.StatusBadRequest, nil)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !middleware.ValidScope(scope) {
+			app.sendErrorResponse(w, fmt.Sprintf("Unknown scope: %s", scope), http.StatusBadRequest, middleware.Scopes)
+			return
+		}
+	}
+
+	key, token, err := app.store.CreateAPIKey(r.Context(), req.Name, req.Scopes)
+	if err != nil {
+		app.logger.Error("Failed to create API key", "error", err)
+		app.sendErrorResponse(w, "Failed to create API key", http.StatusInternalServerError, nil)
+		return
+	}
+
+	// The raw token is only ever shown once.
+	app.sendResponse(w, map[string]interface{}{
+		"key":   key,
+		"token": token,
+	})
+}
+
+func (app *App) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.store.GetAPIKeys(r.Context())
+	if err != nil {
+		app.logger.Error("Failed to fetch API keys", "error", err)
+		app.sendErrorResponse(w, "Failed to fetch API keys", http.StatusInternalServerError, nil)
+		return
+	}
+
+	app.sendResponse(w, keys)
+}
+
+func (app *App) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		app.sendErrorResponse(w, "Invalid key id", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := app.store.RevokeAPIKey(r.Context(), id); err != nil {
+		if err == store.ErrNotExist {
+			app.sendErrorResponse(w, "API key not found", http.StatusNotFound, nil)
+			return
+		}
+		app.logger.Error("Failed to revoke API key", "error", err, "id", id)
+		app.sendErrorResponse(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupAPIKey resolves a bearer token to its scopes for the API key middleware.
+func (app *App) lookupAPIKey(ctx context.Context, token string) ([]string, error) {
+	key, err := app.store.GetAPIKeyByToken(ctx, token)
+	if err != nil {
+		if err == store.ErrNotExist {
+			return nil, middleware.ErrInvalidKey
+		}
+		app.logger.Error("Failed to look up API key", "error", err)
+		return nil, err
+	}
+	return key.Scopes, nil
+}
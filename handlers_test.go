@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mr-karan/lil/models"
+)
+
+func TestForwardRequest(t *testing.T) {
+	both := models.URLData{ForwardPath: true, ForwardQuery: true}
+
+	tests := []struct {
+		name    string
+		target  string
+		rest    string
+		query   string
+		urlData models.URLData
+		want    string
+	}{
+		{"nothing to forward", "https://example.com/docs", "", "", both, "https://example.com/docs"},
+		{"not forwarded", "https://example.com/docs", "guide", "a=1", models.URLData{}, "https://example.com/docs"},
+		{"path", "https://example.com/docs", "guide/intro", "", both, "https://example.com/docs/guide/intro"},
+		{"path trailing slash", "https://example.com/docs/", "guide/", "", both, "https://example.com/docs/guide/"},
+		{"path only", "https://example.com/docs", "guide", "a=1", models.URLData{ForwardPath: true}, "https://example.com/docs/guide"},
+		{"path can't climb", "https://example.com/docs", "../../admin", "", both, "https://example.com/docs/admin"},
+		{"path keeps query", "https://example.com/docs?v=2", "guide", "", both, "https://example.com/docs/guide?v=2"},
+		{"query", "https://example.com/docs", "", "a=1&b=2", both, "https://example.com/docs?a=1&b=2"},
+		{"query only", "https://example.com/docs", "guide", "a=1", models.URLData{ForwardQuery: true}, "https://example.com/docs?a=1"},
+		{"query merged", "https://example.com/?z=9", "", "a=1", both, "https://example.com/?a=1&z=9"},
+		{"target query wins", "https://example.com/?a=target", "", "a=request&b=2", both, "https://example.com/?a=target&b=2"},
+		{"path and query", "https://example.com", "x", "a=1", both, "https://example.com/x?a=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc?"+tt.query, nil)
+			r.SetPathValue("rest", tt.rest)
+			if got := forwardRequest(tt.target, r, tt.urlData); got != tt.want {
+				t.Errorf("forwardRequest(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/file"
@@ -11,14 +12,12 @@ import (
 	flag "github.com/spf13/pflag"
 )
 
-func init() {
-	initConfig()
-}
-
 func initConfig() {
 	f := flag.NewFlagSet("config", flag.ContinueOnError)
 
 	f.String("config", "config.toml", "path to config file")
+	f.Bool("migrate-only", false, "apply pending database migrations and exit")
+	f.Bool("migrate-dry-run", false, "list pending database migrations without applying them and exit")
 
 	if err := f.Parse(os.Args[1:]); err != nil {
 		log.Printf("Error parsing flags: %v", err)
@@ -40,6 +39,14 @@ func initConfig() {
 	log.Println("Configuration loaded successfully")
 }
 
+// durationOr returns the duration config at key, or def if it isn't set.
+func durationOr(key string, def time.Duration) time.Duration {
+	if d := ko.Duration(key); d > 0 {
+		return d
+	}
+	return def
+}
+
 func initLogger(debug bool) *slog.Logger {
 	var level slog.Level
 	if debug {
@@ -45,14 +45,20 @@ func (a *AccessLogDispatcher) formatLogEntry(evt Event) string {
 	// Format timestamp in Apache log format
 	timestamp := time.Now().Format("02/Jan/2006:15:04:05 -0700")
 
-	// Construct the log entry in Combined Log Format
-	// %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"
-	logEntry := fmt.Sprintf("%s - - [%s] \"GET /%s HTTP/1.1\" 302 - \"%s\" \"%s\"\n",
+	country := evt.Country
+	if country == "" {
+		country = "-"
+	}
+
+	// Construct the log entry in Combined Log Format, followed by the country
+	// %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i" country
+	logEntry := fmt.Sprintf("%s - - [%s] \"GET /%s HTTP/1.1\" 302 - \"%s\" \"%s\" %s\n",
 		evt.RemoteAddr,
 		timestamp,
 		evt.ShortCode,
 		evt.Referrer,
 		evt.UserAgent,
+		country,
 	)
 
 	return logEntry
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 )
 
@@ -18,6 +19,11 @@ type Event struct {
 	Timestamp  string
 	ShortCode  string
 	TargetURL  string
+	// Variant is the name of the A/B variant the visitor was sent to, if any.
+	Variant string
+	// Country is the ISO code of the country of the visitor's IP, if a geoip
+	// database is configured and has it.
+	Country string
 }
 
 // Dispatcher interface that all providers must implement
@@ -33,6 +39,10 @@ type Manager struct {
 	eventChan   chan Event
 	logger      *slog.Logger
 	numWorkers  int
+
+	wg     sync.WaitGroup
+	quit   chan struct{}
+	cancel context.CancelFunc
 }
 
 // Config represents analytics configuration
@@ -53,6 +63,7 @@ func NewManager(cfg Config, logger *slog.Logger) (*Manager, error) {
 		logger:      logger,
 		numWorkers:  cfg.NumWorkers,
 		dispatchers: make([]Dispatcher, 0),
+		quit:        make(chan struct{}),
 	}
 
 	// Initialize configured providers
@@ -99,7 +110,9 @@ func initializeProvider(name string, config map[string]interface{}, logger *slog
 
 // Start begins the worker routines
 func (m *Manager) Start(ctx context.Context) {
+	ctx, m.cancel = context.WithCancel(ctx)
 	for i := 0; i < m.numWorkers; i++ {
+		m.wg.Add(1)
 		go m.worker(ctx, i)
 	}
 }
@@ -113,6 +126,31 @@ func (m *Manager) Track(evt Event) {
 	}
 }
 
+// Shutdown stops the workers after they've dispatched the events that are
+// already queued and closes the dispatchers. If ctx expires first, in-flight
+// sends are aborted and the remaining events are dropped.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	close(m.quit)
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.cancel()
+		<-done
+		err = fmt.Errorf("timed out draining analytics events, dropped %d", len(m.eventChan))
+	}
+
+	m.Close()
+	return err
+}
+
 // Close cleans up resources
 func (m *Manager) Close() error {
 	for _, d := range m.dispatchers {
@@ -127,20 +165,37 @@ func (m *Manager) Close() error {
 
 // worker processes events from the channel
 func (m *Manager) worker(ctx context.Context, id int) {
+	defer m.wg.Done()
 	m.logger.Info("starting analytics worker", "worker_id", id)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case evt := <-m.eventChan:
-			for _, d := range m.dispatchers {
-				if err := d.Send(ctx, evt); err != nil {
-					m.logger.Error("failed to send event",
-						"provider", d.Name(),
-						"error", err)
+		case <-m.quit:
+			// Drain the queued events before exiting
+			for ctx.Err() == nil {
+				select {
+				case evt := <-m.eventChan:
+					m.dispatch(ctx, evt)
+				default:
+					return
 				}
 			}
+			return
+		case evt := <-m.eventChan:
+			m.dispatch(ctx, evt)
+		}
+	}
+}
+
+// dispatch sends an event to every dispatcher
+func (m *Manager) dispatch(ctx context.Context, evt Event) {
+	for _, d := range m.dispatchers {
+		if err := d.Send(ctx, evt); err != nil {
+			m.logger.Error("failed to send event",
+				"provider", d.Name(),
+				"error", err)
 		}
 	}
 }
@@ -26,6 +26,8 @@ type plausibleEvent struct {
 	Domain   string `json:"domain"`
 	URL      string `json:"url"`
 	Referrer string `json:"referrer,omitempty"`
+	// Props are custom properties, used to segment A/B links by variant.
+	Props map[string]string `json:"props,omitempty"`
 }
 
 func NewPlausibleDispatcher(config PlausibleConfig, logger *slog.Logger) (*PlausibleDispatcher, error) {
@@ -56,6 +58,9 @@ func (p *PlausibleDispatcher) Send(ctx context.Context, evt Event) error {
 		URL:      evt.URL,
 		Referrer: evt.Referrer,
 	}
+	if evt.Variant != "" {
+		plEvent.Props = map[string]string{"variant": evt.Variant}
+	}
 
 	jsonData, err := json.Marshal(plEvent)
 	if err != nil {
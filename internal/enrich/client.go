@@ -0,0 +1,68 @@
+package enrich
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is how many redirects of a target are followed.
+const maxRedirects = 5
+
+// cgnat is the shared address space of carrier-grade NATs, which isn't
+// covered by netip.Addr.IsPrivate.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// NewClient returns an HTTP client that only connects to public addresses.
+// The check is done on the resolved address of every connection, so it also
+// applies to redirects and to host names that resolve to internal addresses.
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addr) {
+				return fmt.Errorf("refusing to connect to non-public address %s", addr)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			// No proxy, as the address check would apply to it instead
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirected to an unsupported scheme")
+			}
+			return nil
+		},
+	}
+}
+
+// publicAddr reports whether an address is routable on the internet.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnat.Contains(addr)
+}
@@ -0,0 +1,230 @@
+// Package enrich fills in the title, description and favicon of new links from
+// their target pages, in the background so that creating links stays fast.
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Metadata is what's extracted from a target page. Fields the page doesn't
+// have are empty.
+type Metadata struct {
+	Title       string
+	Description string
+	// Favicon is the absolute URL of the icon declared by the page.
+	Favicon string
+}
+
+// IsZero reports whether nothing was found.
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// UpdateFunc stores the metadata of a link.
+type UpdateFunc func(ctx context.Context, shortCode string, md Metadata) error
+
+// Config of an Enricher.
+type Config struct {
+	NumWorkers int
+	QueueSize  int
+	// Timeout limits each fetch, including redirects and reading the body.
+	Timeout time.Duration
+	// MaxBytes is how much of a page is read at most. The metadata is in the
+	// head, so there's no need for the rest.
+	MaxBytes  int64
+	UserAgent string
+	// Client is used for the fetches. If nil, a client that refuses to connect
+	// to private, loopback and link-local addresses is used, so that links
+	// can't be used to probe the internal network. Tests can pass the client
+	// of an httptest server instead.
+	Client *http.Client
+}
+
+type job struct {
+	shortCode string
+	target    string
+}
+
+// Enricher fetches the target pages of links with a pool of workers.
+type Enricher struct {
+	cfg    Config
+	client *http.Client
+	update UpdateFunc
+	logger *slog.Logger
+	queue  chan job
+
+	wg     sync.WaitGroup
+	quit   chan struct{}
+	cancel context.CancelFunc
+}
+
+// New creates an Enricher that stores what it finds with update.
+func New(cfg Config, update UpdateFunc, logger *slog.Logger) *Enricher {
+	if cfg.NumWorkers <= 0 {
+		cfg.NumWorkers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 512 << 10
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = NewClient()
+	}
+
+	return &Enricher{
+		cfg:    cfg,
+		client: client,
+		update: update,
+		logger: logger,
+		queue:  make(chan job, cfg.QueueSize),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Start begins the worker routines.
+func (e *Enricher) Start(ctx context.Context) {
+	ctx, e.cancel = context.WithCancel(ctx)
+	for i := 0; i < e.cfg.NumWorkers; i++ {
+		e.wg.Add(1)
+		go e.worker(ctx)
+	}
+}
+
+// Enqueue schedules the target of a link to be fetched. It never blocks; if
+// the queue is full, the link is skipped.
+func (e *Enricher) Enqueue(shortCode, target string) {
+	select {
+	case e.queue <- job{shortCode: shortCode, target: target}:
+	default:
+		e.logger.Warn("enrich queue full, skipping url", "shortCode", shortCode)
+	}
+}
+
+// Shutdown stops the workers after they've processed the links that are
+// already queued. If ctx expires first, in-flight fetches are aborted and the
+// remaining links are skipped.
+func (e *Enricher) Shutdown(ctx context.Context) error {
+	close(e.quit)
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		<-done
+		return fmt.Errorf("timed out enriching urls, skipped %d", len(e.queue))
+	}
+}
+
+// worker processes links from the queue.
+func (e *Enricher) worker(ctx context.Context) {
+	defer e.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.quit:
+			// Drain the queued links before exiting
+			for ctx.Err() == nil {
+				select {
+				case j := <-e.queue:
+					e.process(ctx, j)
+				default:
+					return
+				}
+			}
+			return
+		case j := <-e.queue:
+			e.process(ctx, j)
+		}
+	}
+}
+
+// process fetches the target of a link and stores what was found.
+func (e *Enricher) process(ctx context.Context, j job) {
+	md, err := e.Fetch(ctx, j.target)
+	if err != nil {
+		e.logger.Debug("failed to fetch url metadata", "error", err, "shortCode", j.shortCode)
+		return
+	}
+	if md.IsZero() {
+		return
+	}
+	if err := e.update(ctx, j.shortCode, md); err != nil {
+		e.logger.Error("failed to store url metadata", "error", err, "shortCode", j.shortCode)
+	}
+}
+
+// Fetch reads the metadata of an HTML page. Responses that aren't HTML are
+// an error.
+func (e *Enricher) Fetch(ctx context.Context, target string) (Metadata, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Metadata{}, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	if e.cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", e.cfg.UserAgent)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Metadata{}, fmt.Errorf("not an html page: %s", contentType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, e.cfg.MaxBytes), contentType)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("decode page: %w", err)
+	}
+
+	// Relative favicons are resolved against the URL the page was redirected to
+	md := Parse(body, resp.Request.URL)
+	if err := ctx.Err(); err != nil && md.IsZero() {
+		return Metadata{}, err
+	}
+	return md, nil
+}
@@ -0,0 +1,73 @@
+package enrich
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, `<html><head><title>Page</title><link rel="icon" href="img/icon.png"></head><body></body></html>`)
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new/page", http.StatusFound)
+	})
+	mux.HandleFunc("/new/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<title>Moved</title><link rel="icon" href="icon.png">`)
+	})
+	mux.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		io.WriteString(w, "<title>Caf\xe9</title>")
+	})
+	mux.HandleFunc("/padded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<head><!--"+strings.Repeat("x", 2048)+"--><title>Too far</title></head>")
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, "<title>Not a page</title>")
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<title>Not found</title>", http.StatusNotFound)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	e := New(Config{MaxBytes: 1024, Client: srv.Client()}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name    string
+		target  string
+		want    Metadata
+		wantErr bool
+	}{
+		{"relative favicon", srv.URL + "/page", Metadata{Title: "Page", Favicon: srv.URL + "/img/icon.png"}, false},
+		{"favicon after redirect", srv.URL + "/old", Metadata{Title: "Moved", Favicon: srv.URL + "/new/icon.png"}, false},
+		{"charset", srv.URL + "/latin1", Metadata{Title: "Café"}, false},
+		{"beyond max bytes", srv.URL + "/padded", Metadata{}, false},
+		{"not html", srv.URL + "/image", Metadata{}, true},
+		{"not found", srv.URL + "/missing", Metadata{}, true},
+		{"unsupported scheme", "ftp://example.com/page", Metadata{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := e.Fetch(context.Background(), tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if md != tt.want {
+				t.Errorf("Fetch(%q) = %+v, want %+v", tt.target, md, tt.want)
+			}
+		})
+	}
+}
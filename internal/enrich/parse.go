@@ -0,0 +1,127 @@
+package enrich
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Limits of the extracted texts, in runes.
+const (
+	maxTitleLen       = 300
+	maxDescriptionLen = 1000
+)
+
+// Parse extracts the metadata from the head of an HTML page. The title and
+// description fall back to their OpenGraph and Twitter card versions, and
+// the favicon is resolved against base (or the <base> of the page).
+func Parse(r io.Reader, base *url.URL) Metadata {
+	var (
+		z                  = html.NewTokenizer(r)
+		title, ogTitle     string
+		desc, ogDesc       string
+		icon, touchIcon    string
+		inTitle, seenTitle bool
+	)
+
+loop:
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// The end of the page, or of what was read of it
+			break loop
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "body":
+				// Everything we need is in the head
+				break loop
+			case "title":
+				// Only the first one counts, e.g. not those of inline SVGs
+				inTitle = !seenTitle && tt == html.StartTagToken
+				seenTitle = true
+			case "base":
+				if href, err := base.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+					base = href
+				}
+			case "meta":
+				key := strings.ToLower(attrs["property"])
+				if key == "" {
+					key = strings.ToLower(attrs["name"])
+				}
+				switch key {
+				case "description":
+					desc = attrs["content"]
+				case "og:description", "twitter:description":
+					if ogDesc == "" {
+						ogDesc = attrs["content"]
+					}
+				case "og:title", "twitter:title":
+					if ogTitle == "" {
+						ogTitle = attrs["content"]
+					}
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					switch rel {
+					case "icon":
+						if icon == "" {
+							icon = attrs["href"]
+						}
+					case "apple-touch-icon":
+						if touchIcon == "" {
+							touchIcon = attrs["href"]
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var md Metadata
+	md.Title = clean(title, maxTitleLen)
+	if md.Title == "" {
+		md.Title = clean(ogTitle, maxTitleLen)
+	}
+	md.Description = clean(desc, maxDescriptionLen)
+	if md.Description == "" {
+		md.Description = clean(ogDesc, maxDescriptionLen)
+	}
+	if icon == "" {
+		icon = touchIcon
+	}
+	if icon != "" {
+		if u, err := base.Parse(strings.TrimSpace(icon)); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			md.Favicon = u.String()
+		}
+	}
+
+	return md
+}
+
+// clean collapses the whitespace of a text and cuts it to max runes.
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > max {
+		s = strings.TrimSpace(string(r[:max-1])) + "…"
+	}
+	return s
+}
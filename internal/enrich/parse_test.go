@@ -0,0 +1,82 @@
+package enrich
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+
+	tests := []struct {
+		name string
+		page string
+		want Metadata
+	}{
+		{
+			"title and description",
+			`<head><title> A   title
+			</title><meta name="description" content="About it"></head>`,
+			Metadata{Title: "A title", Description: "About it"},
+		},
+		{
+			"opengraph fallbacks",
+			`<meta property="og:title" content="OG title"><meta name="twitter:description" content="Card">`,
+			Metadata{Title: "OG title", Description: "Card"},
+		},
+		{
+			"title over opengraph",
+			`<title>Title</title><meta property="og:title" content="OG title">`,
+			Metadata{Title: "Title"},
+		},
+		{
+			"first title only",
+			`<title>Page</title><svg><title>Icon</title></svg>`,
+			Metadata{Title: "Page"},
+		},
+		{
+			"stops at body",
+			`<head></head><body><title>Body</title></body>`,
+			Metadata{},
+		},
+		{
+			"relative favicon",
+			`<link rel="shortcut icon" href="../favicon.ico">`,
+			Metadata{Favicon: "https://example.com/favicon.ico"},
+		},
+		{
+			"base href",
+			`<base href="https://cdn.example.com/assets/"><link rel="icon" href="icon.png">`,
+			Metadata{Favicon: "https://cdn.example.com/assets/icon.png"},
+		},
+		{
+			"touch icon fallback",
+			`<link rel="apple-touch-icon" href="/touch.png">`,
+			Metadata{Favicon: "https://example.com/touch.png"},
+		},
+		{
+			"icon over touch icon",
+			`<link rel="apple-touch-icon" href="/touch.png"><link rel="icon" href="/icon.png">`,
+			Metadata{Favicon: "https://example.com/icon.png"},
+		},
+		{
+			"unsupported favicon scheme",
+			`<link rel="icon" href="javascript:alert(1)">`,
+			Metadata{},
+		},
+		{
+			"long title",
+			`<title>` + strings.Repeat("a", maxTitleLen+10) + `</title>`,
+			Metadata{Title: strings.Repeat("a", maxTitleLen-1) + "…"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(strings.NewReader(tt.page), base); got != tt.want {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
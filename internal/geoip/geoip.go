@@ -0,0 +1,51 @@
+// Package geoip resolves client IPs to countries with a local MaxMind or DB-IP
+// country database (.mmdb), without any network access.
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB is an open country database. It's safe for concurrent use.
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// record is the part of a GeoIP2/GeoLite2 or DB-IP country record we need.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Open memory maps the database file at path.
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geoip database: %w", err)
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of an IP
+// address, e.g. "DE", or "" if it's invalid or not in the database.
+func (db *DB) Country(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	var rec record
+	if err := db.reader.Lookup(addr, &rec); err != nil {
+		return ""
+	}
+	return rec.Country.ISOCode
+}
+
+// Close unmaps the database.
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
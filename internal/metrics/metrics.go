@@ -17,6 +17,24 @@ var (
 	// Counter for failed redirects (404s, expired URLs)
 	RedirectFailuresTotal = metrics.NewCounter(`lil_redirect_failures_total`)
 
+	// Counter for dead links sent to a fallback URL instead of a 404
+	FallbackRedirectsTotal = metrics.NewCounter(`lil_fallback_redirects_total`)
+
+	// Counter for wrong passwords submitted for protected links
+	PasswordFailuresTotal = metrics.NewCounter(`lil_password_failures_total`)
+
+	// Counter for QR codes rendered, including those answered with a 304
+	QRCodesRenderedTotal = metrics.NewCounter(`lil_qr_codes_rendered_total`)
+
 	// Gauge for number of URLs in store
 	URLsStoredGauge = metrics.NewGauge(`lil_urls_stored_total`, nil)
+
+	// Counter for short code lookups served from the URL cache
+	CacheHitsTotal = metrics.NewCounter(`lil_cache_hits_total`)
+
+	// Counter for short code lookups not found in the URL cache
+	CacheMissesTotal = metrics.NewCounter(`lil_cache_misses_total`)
+
+	// Counter for lookups of unknown short codes answered by the negative cache
+	CacheNegativeHitsTotal = metrics.NewCounter(`lil_cache_negative_hits_total`)
 )
@@ -9,18 +9,7 @@ import (
 func BasicAuth(username, password string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get credentials from request
-			user, pass, ok := r.BasicAuth()
-			if !ok {
-				unauthorized(w)
-				return
-			}
-
-			// Constant time comparison to prevent timing attacks
-			usernameMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
-			passwordMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
-
-			if !usernameMatch || !passwordMatch {
+			if !validBasicAuth(r, username, password) {
 				unauthorized(w)
 				return
 			}
@@ -30,6 +19,21 @@ func BasicAuth(username, password string) func(http.Handler) http.Handler {
 	}
 }
 
+// validBasicAuth reports whether the request carries the given Basic Auth credentials.
+func validBasicAuth(r *http.Request, username, password string) bool {
+	// Get credentials from request
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// Constant time comparison to prevent timing attacks
+	usernameMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+
+	return usernameMatch && passwordMatch
+}
+
 func unauthorized(w http.ResponseWriter) {
 	w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
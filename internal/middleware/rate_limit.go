@@ -2,19 +2,68 @@ package middleware
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/mr-karan/lil/internal/utils"
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/middleware/stdlib"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
 )
 
 func RateLimiter(rate limiter.Rate) func(http.Handler) http.Handler {
-	store := memory.NewStore()
-	instance := limiter.New(store, rate)
-	middleware := stdlib.NewMiddleware(instance, stdlib.WithKeyGetter(func(r *http.Request) string {
+	return newRateLimiter(rate, func(r *http.Request) string {
 		// Custom key, e.g., using user agent and IP
 		return r.Header.Get("X-Forwarded-For") + ":" + r.UserAgent()
-	}))
+	})
+}
+
+// PasswordLimiter limits the wrong passwords submitted for a protected link
+// per client IP and short code, regardless of the user agent or the rest of
+// the path. Only failures count in the end, so that visitors sharing an IP who follow
+// links without a password are never limited. The client IP is only taken
+// from X-Forwarded-For if the request comes from one of the trusted proxies.
+type PasswordLimiter struct {
+	limiter *limiter.Limiter
+	proxies utils.TrustedProxies
+}
+
+// NewPasswordLimiter creates a PasswordLimiter allowing rate failures.
+func NewPasswordLimiter(rate limiter.Rate, proxies utils.TrustedProxies) *PasswordLimiter {
+	return &PasswordLimiter{
+		limiter: limiter.New(memory.NewStore(), rate),
+		proxies: proxies,
+	}
+}
+
+// Reserve takes one of the client's attempts on the link before its password
+// is checked, so that concurrent guesses can't all get past the limit before
+// any of them has failed. If none are left, it reports false and when there
+// are new ones.
+func (l *PasswordLimiter) Reserve(r *http.Request) (bool, time.Time) {
+	ctx, err := l.limiter.Increment(r.Context(), l.key(r), 1)
+	if err != nil {
+		return true, time.Time{}
+	}
+	return !ctx.Reached, time.Unix(ctx.Reset, 0)
+}
+
+// Release gives back an attempt reserved for a correct password. reset is the
+// time returned by Reserve: once it has passed, the attempt was counted in a
+// window that's already over.
+func (l *PasswordLimiter) Release(r *http.Request, reset time.Time) {
+	if time.Now().Before(reset) {
+		_, _ = l.limiter.Increment(r.Context(), l.key(r), -1)
+	}
+}
+
+func (l *PasswordLimiter) key(r *http.Request) string {
+	return l.proxies.ClientIP(r) + ":" + r.PathValue("shortCode")
+}
+
+func newRateLimiter(rate limiter.Rate, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	store := memory.NewStore()
+	instance := limiter.New(store, rate)
+	middleware := stdlib.NewMiddleware(instance, stdlib.WithKeyGetter(key))
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
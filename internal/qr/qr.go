@@ -0,0 +1,240 @@
+// Package qr renders QR codes as PNG or SVG images.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Output formats.
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Limits of the options.
+const (
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+// levels maps the error correction levels to those of the encoder. Higher
+// levels survive more damage but need a denser code.
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,     // 7%
+	"M": qrcode.Medium,  // 15%
+	"Q": qrcode.High,    // 25%
+	"H": qrcode.Highest, // 30%
+}
+
+// ErrTooSmall is returned when the image is too small to draw every module of
+// the code at least one pixel wide.
+var ErrTooSmall = errors.New("size is too small for the content")
+
+// Options control how a QR code is drawn.
+type Options struct {
+	Format string
+	// Size is the width and height of the image in pixels.
+	Size int
+	// Margin is the width of the quiet zone around the code, in modules.
+	Margin     int
+	Level      string
+	Foreground color.NRGBA
+	Background color.NRGBA
+}
+
+// DefaultOptions returns the options of a plain black on white PNG.
+func DefaultOptions() Options {
+	return Options{
+		Format:     FormatPNG,
+		Size:       DefaultSize,
+		Margin:     DefaultMargin,
+		Level:      "M",
+		Foreground: color.NRGBA{A: 0xff},
+		Background: color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// ParseOptions reads the options from query parameters: format, size, margin,
+// level, fg and bg. Missing ones are left at their default. Colours are hex
+// RGB or RGBA values, with or without a leading "#".
+func ParseOptions(params url.Values) (Options, error) {
+	o := DefaultOptions()
+
+	if v := params.Get("format"); v != "" {
+		v = strings.ToLower(v)
+		if v != FormatPNG && v != FormatSVG {
+			return o, errors.New("format must be png or svg")
+		}
+		o.Format = v
+	}
+	if v := params.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < MinSize || n > MaxSize {
+			return o, fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+		}
+		o.Size = n
+	}
+	if v := params.Get("margin"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > MaxMargin {
+			return o, fmt.Errorf("margin must be between 0 and %d", MaxMargin)
+		}
+		o.Margin = n
+	}
+	if v := params.Get("level"); v != "" {
+		v = strings.ToUpper(v)
+		if _, ok := levels[v]; !ok {
+			return o, errors.New("level must be one of L, M, Q or H")
+		}
+		o.Level = v
+	}
+
+	var err error
+	if v := params.Get("fg"); v != "" {
+		if o.Foreground, err = parseColor(v); err != nil {
+			return o, fmt.Errorf("invalid fg: %w", err)
+		}
+	}
+	if v := params.Get("bg"); v != "" {
+		if o.Background, err = parseColor(v); err != nil {
+			return o, fmt.Errorf("invalid bg: %w", err)
+		}
+	}
+
+	return o, nil
+}
+
+// ContentType returns the media type of the images rendered with the options.
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Render encodes content as a QR code and draws it.
+func Render(content string, o Options) ([]byte, error) {
+	level, ok := levels[o.Level]
+	if !ok {
+		return nil, fmt.Errorf("unknown error correction level: %s", o.Level)
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, fmt.Errorf("encode qr code: %w", err)
+	}
+	// The quiet zone is drawn here instead, to make it configurable.
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+
+	if o.Format == FormatSVG {
+		return renderSVG(bitmap, o), nil
+	}
+	return renderPNG(bitmap, o)
+}
+
+// renderPNG draws every module as a square of whole pixels, centring the code
+// in the image if the size isn't a multiple of its width.
+func renderPNG(bitmap [][]bool, o Options) ([]byte, error) {
+	modules := len(bitmap) + 2*o.Margin
+	scale := o.Size / modules
+	if scale < 1 {
+		return nil, ErrTooSmall
+	}
+	offset := (o.Size-scale*modules)/2 + o.Margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, o.Size, o.Size), color.Palette{o.Background, o.Foreground})
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				start := img.PixOffset(offset+x*scale, offset+y*scale+py)
+				for px := 0; px < scale; px++ {
+					img.Pix[start+px] = 1
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderSVG draws the code in a viewBox of one unit per module, with each run
+// of dark modules in a row as one path segment.
+func renderSVG(bitmap [][]bool, o Options) []byte {
+	modules := len(bitmap) + 2*o.Margin
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		o.Size, o.Size, modules, modules)
+	if o.Background.A > 0 {
+		fmt.Fprintf(&b, `<rect width="100%%" height="100%%"%s/>`, svgFill(o.Background))
+	}
+
+	b.WriteString(`<path d="`)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", x+o.Margin, y+o.Margin, run, run)
+			x += run
+		}
+	}
+	fmt.Fprintf(&b, `"%s/></svg>`, svgFill(o.Foreground))
+
+	return b.Bytes()
+}
+
+// svgFill returns the fill attributes of a colour.
+func svgFill(c color.NRGBA) string {
+	attr := fmt.Sprintf(` fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+	if c.A < 0xff {
+		attr += fmt.Sprintf(` fill-opacity="%.3g"`, float64(c.A)/0xff)
+	}
+	return attr
+}
+
+// parseColor parses a hex colour in the RGB, RRGGBB or RRGGBBAA form.
+func parseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("%q is not a hex colour", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("%q is not a hex colour", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
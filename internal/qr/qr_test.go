@@ -0,0 +1,56 @@
+package qr
+
+import (
+	"image/color"
+	"net/url"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	withOpts := func(f func(o *Options)) Options {
+		o := DefaultOptions()
+		f(&o)
+		return o
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    Options
+		wantErr bool
+	}{
+		{"defaults", "", DefaultOptions(), false},
+		{"svg", "format=SVG", withOpts(func(o *Options) { o.Format = FormatSVG }), false},
+		{"size", "size=512", withOpts(func(o *Options) { o.Size = 512 }), false},
+		{"margin", "margin=0", withOpts(func(o *Options) { o.Margin = 0 }), false},
+		{"level", "level=h", withOpts(func(o *Options) { o.Level = "H" }), false},
+		{"rgb colour", "fg=%23112233", withOpts(func(o *Options) { o.Foreground = color.NRGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff} }), false},
+		{"short colour", "bg=fa0", withOpts(func(o *Options) { o.Background = color.NRGBA{R: 0xff, G: 0xaa, A: 0xff} }), false},
+		{"rgba colour", "bg=00000000", withOpts(func(o *Options) { o.Background = color.NRGBA{} }), false},
+		{"unknown format", "format=gif", Options{}, true},
+		{"size too small", "size=10", Options{}, true},
+		{"size too large", "size=4096", Options{}, true},
+		{"size not a number", "size=big", Options{}, true},
+		{"negative margin", "margin=-1", Options{}, true},
+		{"margin too large", "margin=17", Options{}, true},
+		{"unknown level", "level=X", Options{}, true},
+		{"invalid colour", "fg=red", Options{}, true},
+		{"colour too long", "bg=1122334455", Options{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseOptions(params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOptions(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseOptions(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
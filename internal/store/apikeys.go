@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mr-karan/lil/models"
+)
+
+// apiKeyPrefix is prepended to every generated token so that leaked keys are easy to spot.
+const apiKeyPrefix = "lil_"
+
+// CreateAPIKey generates a new API key with the given scopes. The raw token is only
+// returned here; the database only ever stores its SHA-256 hash.
+func (s *SQLStore) CreateAPIKey(ctx context.Context, name string, scopes []string) (models.APIKey, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return models.APIKey{}, "", err
+	}
+	token := apiKeyPrefix + hex.EncodeToString(secret)
+
+	key := models.APIKey{
+		ID:        generateRandomString(12),
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO api_keys (id, name, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?)`),
+		key.ID, key.Name, hashAPIKey(token), strings.Join(key.Scopes, ","), key.CreatedAt)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	return key, token, nil
+}
+
+// GetAPIKeyByToken looks up the API key matching the raw bearer token.
+func (s *SQLStore) GetAPIKeyByToken(ctx context.Context, token string) (models.APIKey, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return models.APIKey{}, ErrNotExist
+	}
+
+	var (
+		key    models.APIKey
+		scopes string
+	)
+	err := s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT id, name, scopes, created_at FROM api_keys WHERE key_hash = ?`),
+		hashAPIKey(token)).Scan(&key.ID, &key.Name, &scopes, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, ErrNotExist
+		}
+		return models.APIKey{}, err
+	}
+	key.Scopes = splitScopes(scopes)
+
+	return key, nil
+}
+
+// GetAPIKeys returns all active API keys, newest first.
+func (s *SQLStore) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT id, name, scopes, created_at FROM api_keys ORDER BY created_at DESC`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var (
+			key    models.APIKey
+			scopes string
+		)
+		if err := rows.Scan(&key.ID, &key.Name, &scopes, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		key.Scopes = splitScopes(scopes)
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey permanently deletes an API key.
+func (s *SQLStore) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM api_keys WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func splitScopes(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
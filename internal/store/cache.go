@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+
+	"github.com/mr-karan/lil/internal/metrics"
+	"github.com/mr-karan/lil/models"
+)
+
+// Cache modes control how much of the urls table is kept in memory.
+const (
+	// CacheFull loads every URL into memory at startup. Lookups never hit the
+	// database, but memory and boot time grow with the table.
+	CacheFull = "full"
+	// CacheLRU keeps a bounded number of recently used URLs in memory and
+	// reads through to the database on a miss.
+	CacheLRU = "lru"
+
+	defaultCacheSize = 100000
+)
+
+// urlCache holds URLs in memory in front of the database. Writes are guarded
+// by SQLStore.mu.
+type urlCache interface {
+	get(shortCode string) (models.URLData, bool)
+	set(urlData models.URLData)
+	remove(shortCode string)
+	// complete reports whether every URL is cached, in which case a miss
+	// means that the URL doesn't exist.
+	complete() bool
+	// missing reports whether the short code was recently looked up and not found.
+	missing(shortCode string) bool
+	setMissing(shortCode string)
+}
+
+// fullCache holds every URL in the database.
+type fullCache map[string]models.URLData
+
+func (c fullCache) get(shortCode string) (models.URLData, bool) {
+	urlData, ok := c[shortCode]
+	return urlData, ok
+}
+
+func (c fullCache) set(urlData models.URLData) { c[urlData.ShortCode] = urlData }
+func (c fullCache) remove(shortCode string)    { delete(c, shortCode) }
+func (c fullCache) complete() bool             { return true }
+func (c fullCache) missing(string) bool        { return false }
+func (c fullCache) setMissing(string)          {}
+
+// lruCache holds the most recently used URLs, and remembers short codes that
+// don't exist for a while so that scans of random codes don't all end up
+// hitting the database.
+type lruCache struct {
+	urls *lru.Cache[string, models.URLData]
+	// neg is nil when negative caching is disabled.
+	neg *expirable.LRU[string, struct{}]
+}
+
+func newLRUCache(size, negSize int, negTTL time.Duration) (*lruCache, error) {
+	urls, err := lru.New[string, models.URLData](size)
+	if err != nil {
+		return nil, fmt.Errorf("create url cache: %w", err)
+	}
+
+	c := &lruCache{urls: urls}
+	if negSize > 0 && negTTL > 0 {
+		c.neg = expirable.NewLRU[string, struct{}](negSize, nil, negTTL)
+	}
+	return c, nil
+}
+
+func (c *lruCache) get(shortCode string) (models.URLData, bool) {
+	return c.urls.Get(shortCode)
+}
+
+func (c *lruCache) set(urlData models.URLData) {
+	c.urls.Add(urlData.ShortCode, urlData)
+	if c.neg != nil {
+		c.neg.Remove(urlData.ShortCode)
+	}
+}
+
+func (c *lruCache) remove(shortCode string) {
+	c.urls.Remove(shortCode)
+}
+
+func (c *lruCache) complete() bool { return false }
+
+func (c *lruCache) missing(shortCode string) bool {
+	return c.neg != nil && c.neg.Contains(shortCode)
+}
+
+func (c *lruCache) setMissing(shortCode string) {
+	if c.neg != nil {
+		c.neg.Add(shortCode, struct{}{})
+	}
+}
+
+// lookup returns a URL from the cache, reading through to the database on a
+// miss. Expired URLs are returned as well.
+func (s *SQLStore) lookup(ctx context.Context, shortCode string) (models.URLData, bool, error) {
+	s.mu.RLock()
+	urlData, ok := s.cache.get(shortCode)
+	complete, missing := s.cache.complete(), s.cache.missing(shortCode)
+	gen := s.cacheGen
+	s.mu.RUnlock()
+
+	switch {
+	case ok:
+		metrics.CacheHitsTotal.Inc()
+		return urlData, true, nil
+	case complete:
+		metrics.CacheMissesTotal.Inc()
+		return models.URLData{}, false, nil
+	case missing:
+		metrics.CacheNegativeHitsTotal.Inc()
+		return models.URLData{}, false, nil
+	}
+
+	metrics.CacheMissesTotal.Inc()
+	urlData, ok, err := s.fetch(ctx, shortCode)
+	if err != nil {
+		return models.URLData{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The URL may have been created while it was being fetched.
+	if cached, exists := s.cache.get(shortCode); exists {
+		return cached, true, nil
+	}
+	// Don't cache what was read if the URL was updated or deleted in the meantime.
+	if gen != s.cacheGen {
+		return urlData, ok, nil
+	}
+	if ok {
+		s.cache.set(urlData)
+	} else if _, pending := s.pendingURL(shortCode); !pending {
+		s.cache.setMissing(shortCode)
+	}
+
+	return urlData, ok, nil
+}
+
+// exists reports whether a short code is taken. s.mu must be held.
+func (s *SQLStore) exists(ctx context.Context, shortCode string) (bool, error) {
+	if _, ok := s.reserved[shortCode]; ok {
+		return true, nil
+	}
+	if _, ok := s.cache.get(shortCode); ok {
+		return true, nil
+	}
+	if s.cache.complete() || s.cache.missing(shortCode) {
+		return false, nil
+	}
+
+	_, ok, err := s.fetch(ctx, shortCode)
+	return ok, err
+}
+
+// fetch reads a URL that's not in the cache from the write buffer or the database.
+func (s *SQLStore) fetch(ctx context.Context, shortCode string) (models.URLData, bool, error) {
+	if urlData, ok := s.pendingURL(shortCode); ok {
+		return urlData, true, nil
+	}
+
+	urlData, err := scanURL(s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT `+urlColumnList("")+` FROM urls WHERE short_code = ?`), shortCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.URLData{}, false, nil
+	}
+	if err != nil {
+		return models.URLData{}, false, err
+	}
+
+	return urlData, true, nil
+}
+
+// pendingURL returns a URL that has been accepted but not yet written to the database.
+func (s *SQLStore) pendingURL(shortCode string) (models.URLData, bool) {
+	s.bufMu.Lock()
+	defer s.bufMu.Unlock()
+
+	urlData, ok := s.pending[shortCode]
+	return urlData, ok
+}
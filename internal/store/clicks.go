@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mr-karan/lil/models"
+)
+
+// clickDayFormat is the layout of the daily click buckets. Days are in UTC.
+const clickDayFormat = "2006-01-02"
+
+// clickCounter accumulates clicks for a short code in memory between flushes.
+type clickCounter struct {
+	total         int64
+	lastClickedAt time.Time
+	daily         map[string]int64
+}
+
+// RecordClick counts a click on a short code. Clicks are accumulated in memory
+// and written to the database in batches by the flush worker.
+func (s *SQLStore) RecordClick(shortCode string, at time.Time) {
+	day := at.UTC().Format(clickDayFormat)
+
+	s.clicksMu.Lock()
+	defer s.clicksMu.Unlock()
+
+	c, ok := s.clicks[shortCode]
+	if !ok {
+		c = &clickCounter{daily: make(map[string]int64)}
+		s.clicks[shortCode] = c
+	}
+	c.total++
+	c.lastClickedAt = at
+	c.daily[day]++
+}
+
+// ConsumeClick uses up one click of a click-limited URL and returns how many
+// are left. The count is decremented in the database, so concurrent redirects
+// (even across instances) can never use up more clicks than the limit. It
+// returns ErrNotExist if there are no clicks left.
+func (s *SQLStore) ConsumeClick(ctx context.Context, shortCode string) (int64, error) {
+	var remaining int64
+	err := s.db.QueryRowContext(ctx,
+		s.rebind(`UPDATE urls SET remaining_clicks = remaining_clicks - 1
+		WHERE short_code = ? AND remaining_clicks > 0
+		RETURNING remaining_clicks`), shortCode).Scan(&remaining)
+	if errors.Is(err, sql.ErrNoRows) {
+		remaining = 0
+		err = ErrNotExist
+	} else if err != nil {
+		return 0, err
+	}
+
+	// Keep the cached copy in step
+	s.mu.Lock()
+	if urlData, ok := s.cache.get(shortCode); ok && urlData.RemainingClicks != nil {
+		urlData.RemainingClicks = &remaining
+		s.cache.set(urlData)
+	}
+	s.mu.Unlock()
+
+	return remaining, err
+}
+
+// flushClicks writes the accumulated click counters to the database. On failure
+// the counters are merged back so they're retried on the next flush.
+func (s *SQLStore) flushClicks() {
+	s.clicksMu.Lock()
+	if len(s.clicks) == 0 {
+		s.clicksMu.Unlock()
+		return
+	}
+	pending := s.clicks
+	s.clicks = make(map[string]*clickCounter)
+	s.clicksMu.Unlock()
+
+	if err := s.doFlushClicks(pending); err != nil {
+		s.logger.Error("failed to flush clicks, will retry", "error", err, "count", len(pending))
+
+		s.clicksMu.Lock()
+		for shortCode, p := range pending {
+			c, ok := s.clicks[shortCode]
+			if !ok {
+				s.clicks[shortCode] = p
+				continue
+			}
+			c.total += p.total
+			if p.lastClickedAt.After(c.lastClickedAt) {
+				c.lastClickedAt = p.lastClickedAt
+			}
+			for day, n := range p.daily {
+				c.daily[day] += n
+			}
+		}
+		s.clicksMu.Unlock()
+	}
+}
+
+func (s *SQLStore) doFlushClicks(pending map[string]*clickCounter) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for shortCode, c := range pending {
+		if _, err := tx.Exec(
+			s.rebind(`INSERT INTO url_clicks (short_code, total, last_clicked_at) VALUES (?, ?, ?)
+			ON CONFLICT(short_code) DO UPDATE SET
+				total = url_clicks.total + excluded.total,
+				last_clicked_at = excluded.last_clicked_at`),
+			shortCode, c.total, c.lastClickedAt); err != nil {
+			return fmt.Errorf("upsert clicks: %w", err)
+		}
+
+		for day, n := range c.daily {
+			if _, err := tx.Exec(
+				s.rebind(`INSERT INTO url_clicks_daily (short_code, day, clicks) VALUES (?, ?, ?)
+				ON CONFLICT(short_code, day) DO UPDATE SET
+					clicks = url_clicks_daily.clicks + excluded.clicks`),
+				shortCode, day, n); err != nil {
+				return fmt.Errorf("upsert daily clicks: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	s.logger.Debug("flushed clicks to database", "count", len(pending))
+	return nil
+}
+
+// GetStats returns the click statistics of a short code, including daily
+// buckets for the last `days` days (oldest first).
+func (s *SQLStore) GetStats(ctx context.Context, shortCode string, days int) (models.URLStats, error) {
+	urlData, exists, err := s.lookup(ctx, shortCode)
+	if err != nil {
+		return models.URLStats{}, err
+	}
+	if !exists || (urlData.Expired() && urlData.ExpiredRedirectURL == "") {
+		return models.URLStats{}, ErrNotExist
+	}
+
+	stats := models.URLStats{ShortCode: shortCode}
+
+	var lastClickedAt sql.NullTime
+	err = s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT total, last_clicked_at FROM url_clicks WHERE short_code = ?`), shortCode).
+		Scan(&stats.Clicks, &lastClickedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.URLStats{}, err
+	}
+	if lastClickedAt.Valid {
+		stats.LastClickedAt = &lastClickedAt.Time
+	}
+
+	// Build empty buckets for the requested window so that days without clicks show up as zero.
+	today := time.Now().UTC()
+	from := today.AddDate(0, 0, -(days - 1)).Format(clickDayFormat)
+	daily := make(map[string]int64, days)
+
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT day, clicks FROM url_clicks_daily WHERE short_code = ? AND day >= ?`), shortCode, from)
+	if err != nil {
+		return models.URLStats{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			day    string
+			clicks int64
+		)
+		if err := rows.Scan(&day, &clicks); err != nil {
+			return models.URLStats{}, err
+		}
+		daily[day] = clicks
+	}
+	if err := rows.Err(); err != nil {
+		return models.URLStats{}, err
+	}
+
+	// Merge clicks that haven't been flushed yet.
+	s.clicksMu.Lock()
+	if c, ok := s.clicks[shortCode]; ok {
+		stats.Clicks += c.total
+		if stats.LastClickedAt == nil || c.lastClickedAt.After(*stats.LastClickedAt) {
+			t := c.lastClickedAt
+			stats.LastClickedAt = &t
+		}
+		for day, n := range c.daily {
+			daily[day] += n
+		}
+	}
+	s.clicksMu.Unlock()
+
+	stats.Daily = make([]models.DailyClicks, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format(clickDayFormat)
+		stats.Daily = append(stats.Daily, models.DailyClicks{Date: day, Clicks: daily[day]})
+	}
+
+	return stats, nil
+}
+
+// addPendingClicks adds clicks that haven't been flushed yet to a list of URLs.
+func (s *SQLStore) addPendingClicks(urls []models.URLData) {
+	s.clicksMu.Lock()
+	defer s.clicksMu.Unlock()
+
+	for i := range urls {
+		c, ok := s.clicks[urls[i].ShortCode]
+		if !ok {
+			continue
+		}
+		urls[i].Clicks += c.total
+		if urls[i].LastClickedAt == nil || c.lastClickedAt.After(*urls[i].LastClickedAt) {
+			t := c.lastClickedAt
+			urls[i].LastClickedAt = &t
+		}
+	}
+}
+
+// deleteClicks removes all click data of a short code.
+func (s *SQLStore) deleteClicks(ctx context.Context, shortCode string) error {
+	s.clicksMu.Lock()
+	delete(s.clicks, shortCode)
+	s.clicksMu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM url_clicks WHERE short_code = ?`), shortCode); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM url_clicks_daily WHERE short_code = ?`), shortCode); err != nil {
+		return err
+	}
+	return nil
+}
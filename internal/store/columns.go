@@ -0,0 +1,195 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mr-karan/lil/models"
+)
+
+// urlColumns are the columns of the urls table that make up a models.URLData,
+// in the order used by scanURL and urlArgs.
+var urlColumns = []string{
+	"short_code",
+	"url",
+	"title",
+	"created_at",
+	"expires_at",
+	"active_from",
+	"redirect_type",
+	"password_hash",
+	"max_clicks",
+	"remaining_clicks",
+	"expired_redirect_url",
+	"variants",
+	"sticky_variants",
+	"rules",
+	"forward_query",
+	"forward_path",
+	"interstitial",
+	"utm_source",
+	"utm_medium",
+	"utm_campaign",
+	"utm_term",
+	"utm_content",
+	"og_title",
+	"og_description",
+	"og_image",
+	"description",
+	"favicon",
+}
+
+// urlColumnList returns urlColumns for a SELECT or INSERT, each prefixed with
+// the table alias if one is given.
+func urlColumnList(alias string) string {
+	if alias == "" {
+		return strings.Join(urlColumns, ", ")
+	}
+
+	cols := make([]string, len(urlColumns))
+	for i, c := range urlColumns {
+		cols[i] = alias + "." + c
+	}
+	return strings.Join(cols, ", ")
+}
+
+// urlPlaceholders returns the VALUES tuple for one row of urlColumns.
+func urlPlaceholders() string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", len(urlColumns)), ",") + ")"
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanURL scans a row selected with urlColumnList. Any extra destinations are
+// scanned from the columns that follow.
+func scanURL(row scanner, extra ...interface{}) (models.URLData, error) {
+	var (
+		urlData    models.URLData
+		title      sql.NullString
+		expiresAt  sql.NullTime
+		activeFrom sql.NullTime
+		remaining  sql.NullInt64
+		variants   string
+		rules      string
+		utm        models.UTM
+		og         models.OpenGraph
+	)
+
+	dest := append([]interface{}{
+		&urlData.ShortCode,
+		&urlData.URL,
+		&title,
+		&urlData.CreatedAt,
+		&expiresAt,
+		&activeFrom,
+		&urlData.RedirectType,
+		&urlData.PasswordHash,
+		&urlData.MaxClicks,
+		&remaining,
+		&urlData.ExpiredRedirectURL,
+		&variants,
+		&urlData.StickyVariants,
+		&rules,
+		&urlData.ForwardQuery,
+		&urlData.ForwardPath,
+		&urlData.Interstitial,
+		&utm.Source,
+		&utm.Medium,
+		&utm.Campaign,
+		&utm.Term,
+		&utm.Content,
+		&og.Title,
+		&og.Description,
+		&og.Image,
+		&urlData.Description,
+		&urlData.Favicon,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return models.URLData{}, err
+	}
+
+	urlData.Title = title.String
+	if expiresAt.Valid {
+		urlData.ExpiresAt = &expiresAt.Time
+	}
+	if activeFrom.Valid {
+		urlData.ActiveFrom = &activeFrom.Time
+	}
+	if remaining.Valid {
+		urlData.RemainingClicks = &remaining.Int64
+	}
+	if !utm.IsZero() {
+		urlData.UTM = &utm
+	}
+	if !og.IsZero() {
+		urlData.OG = &og
+	}
+	if variants != "" {
+		if err := json.Unmarshal([]byte(variants), &urlData.Variants); err != nil {
+			return models.URLData{}, fmt.Errorf("decode variants of %s: %w", urlData.ShortCode, err)
+		}
+	}
+	if rules != "" {
+		if err := json.Unmarshal([]byte(rules), &urlData.Rules); err != nil {
+			return models.URLData{}, fmt.Errorf("decode rules of %s: %w", urlData.ShortCode, err)
+		}
+	}
+
+	return urlData, nil
+}
+
+// urlArgs returns the values of a URL in the order of urlColumns.
+func urlArgs(u models.URLData) []interface{} {
+	var utm models.UTM
+	if u.UTM != nil {
+		utm = *u.UTM
+	}
+	var og models.OpenGraph
+	if u.OG != nil {
+		og = *u.OG
+	}
+
+	return []interface{}{
+		u.ShortCode,
+		u.URL,
+		u.Title,
+		u.CreatedAt,
+		u.ExpiresAt,
+		u.ActiveFrom,
+		u.RedirectType,
+		u.PasswordHash,
+		u.MaxClicks,
+		u.RemainingClicks,
+		u.ExpiredRedirectURL,
+		encodeList(u.Variants),
+		u.StickyVariants,
+		encodeList(u.Rules),
+		u.ForwardQuery,
+		u.ForwardPath,
+		u.Interstitial,
+		utm.Source,
+		utm.Medium,
+		utm.Campaign,
+		utm.Term,
+		utm.Content,
+		og.Title,
+		og.Description,
+		og.Image,
+		u.Description,
+		u.Favicon,
+	}
+}
+
+// encodeList returns the value of a JSON list column, "" if the list is empty.
+func encodeList[T any](items []T) string {
+	if len(items) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(items)
+	return string(b)
+}
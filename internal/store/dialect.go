@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect captures the differences between the SQL databases supported by SQLStore.
+type dialect struct {
+	// name is the value of the `db.driver` config.
+	name string
+	// driver is the database/sql driver name.
+	driver string
+	// pragmas is executed before the migrations, if set.
+	pragmas string
+	// migrationLock is executed at the start of every migration transaction to
+	// keep concurrently starting instances from applying the same migration.
+	migrationLock string
+	// rawTime wraps a timestamp column so that it's read back exactly as it's
+	// stored, for use in pagination cursors.
+	rawTime func(col string) string
+	// search returns a condition (against `urls u`) matching URLs by a
+	// free-text search term, and its arguments.
+	search func(term string) (string, []interface{})
+	// numberedParams is set for databases using $1, $2, ... placeholders.
+	numberedParams bool
+}
+
+var dialects = map[string]dialect{
+	"":         sqliteDialect,
+	"sqlite":   sqliteDialect,
+	"postgres": postgresDialect,
+}
+
+// dsn returns the data source name to open for the config.
+func (d dialect) dsn(cfg Conf) string {
+	if d.name == "postgres" {
+		return cfg.DSN
+	}
+	return cfg.DBPath
+}
+
+// applyPragmas applies the driver specific settings, if any.
+func applyPragmas(db *sql.DB, d dialect) error {
+	if d.pragmas == "" {
+		return nil
+	}
+	_, err := db.Exec(d.pragmas)
+	return err
+}
+
+// rebind converts the `?` placeholders used throughout the store to the
+// placeholder style of the configured database.
+func (s *SQLStore) rebind(query string) string {
+	return s.dialect.rebind(query)
+}
+
+func (d dialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+
+	var (
+		sb strings.Builder
+		n  int
+	)
+	sb.Grow(len(query) + 16)
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			sb.WriteByte(query[i])
+			continue
+		}
+		n++
+		sb.WriteByte('$')
+		sb.WriteString(strconv.Itoa(n))
+	}
+
+	return sb.String()
+}
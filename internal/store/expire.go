@@ -8,7 +8,7 @@ import (
 )
 
 // StartExpiryWorker starts a background goroutine that periodically checks and removes expired URLs
-func (s *Store) StartExpiryWorker(ctx context.Context) {
+func (s *SQLStore) StartExpiryWorker(ctx context.Context) {
 	ticker := time.NewTicker(24 * time.Hour)
 	go func() {
 		for {
@@ -26,20 +26,22 @@ func (s *Store) StartExpiryWorker(ctx context.Context) {
 	s.logger.Info("started URL expiry worker")
 }
 
-// removeExpiredURLs removes all expired URLs from both the database and cache
-func (s *Store) removeExpiredURLs(ctx context.Context) error {
+// removeExpiredURLs removes all expired URLs, and those out of clicks, from both the database and cache.
+// URLs with an expired_redirect_url are kept.
+func (s *SQLStore) removeExpiredURLs(ctx context.Context) error {
 	// Query for expired URLs
 	rows, err := s.db.QueryContext(ctx,
-		`DELETE FROM urls
-		 WHERE expires_at IS NOT NULL
-		 AND expires_at <= datetime('now')
-		 RETURNING short_code`)
+		s.rebind(`DELETE FROM urls
+		 WHERE ((expires_at IS NOT NULL AND expires_at <= ?) OR remaining_clicks <= 0)
+		 AND expired_redirect_url = ''
+		 RETURNING short_code`), time.Now())
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
 	// Remove expired URLs from cache
+	var expired []string
 	s.mu.Lock()
 	for rows.Next() {
 		var shortCode string
@@ -47,15 +49,25 @@ func (s *Store) removeExpiredURLs(ctx context.Context) error {
 			s.mu.Unlock()
 			return err
 		}
-		delete(s.cache, shortCode)
+		s.cache.remove(shortCode)
+		expired = append(expired, shortCode)
 	}
-	// Update metrics
-	metrics.URLsStoredGauge.Set(float64(len(s.cache)))
+	s.cacheGen++
 	s.mu.Unlock()
+	// Update metrics
+	metrics.URLsStoredGauge.Add(-float64(len(expired)))
 
 	if err := rows.Err(); err != nil {
 		return err
 	}
+	rows.Close()
+
+	// Drop the click data of the removed URLs
+	for _, shortCode := range expired {
+		if err := s.deleteClicks(ctx, shortCode); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mr-karan/lil/models"
+)
+
+const (
+	journalPut = "put"
+	journalDel = "del"
+)
+
+// journalEntry is a single line of the write journal.
+type journalEntry struct {
+	Op  string         `json:"op"`
+	URL models.URLData `json:"url"`
+	// PasswordHash is kept alongside the URL as it's never marshalled with it.
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// journal is an append-only log of URLs that have been accepted but not yet
+// flushed to the database. Every append is fsynced before returning, so an
+// acknowledged URL survives a crash: the journal is replayed by New on the next
+// start. Whenever URLs have been flushed, it's rewritten with only the ones
+// that are still pending, so that it doesn't grow under a steady stream of
+// writes.
+type journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	// live are the entries of the URLs appended but not yet flushed or
+	// deleted, by short code.
+	live map[string]journalEntry
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &journal{path: path, f: f, live: make(map[string]journalEntry)}, nil
+}
+
+// append writes entries to the journal and syncs it to disk.
+func (j *journal) append(entries ...journalEntry) error {
+	buf, err := marshalEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.f.Write(buf); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Op == journalPut {
+			j.live[e.URL.ShortCode] = e
+		} else {
+			delete(j.live, e.URL.ShortCode)
+		}
+	}
+
+	return nil
+}
+
+// flushed marks URLs as persisted and compacts the journal to the ones that
+// are still pending.
+func (j *journal) flushed(shortCodes ...string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, shortCode := range shortCodes {
+		delete(j.live, shortCode)
+	}
+	if len(j.live) == 0 {
+		return j.truncate()
+	}
+	return j.compact()
+}
+
+// truncate empties the journal. The caller must hold j.mu.
+func (j *journal) truncate() error {
+	if err := j.f.Truncate(0); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// compact replaces the journal with one holding only the live entries. The new
+// file is written next to it and renamed over it, so that a crash leaves either
+// of them complete. The caller must hold j.mu.
+func (j *journal) compact() error {
+	entries := make([]journalEntry, 0, len(j.live))
+	for _, e := range j.live {
+		entries = append(entries, e)
+	}
+	buf, err := marshalEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(j.path)); err != nil {
+		return err
+	}
+
+	// Keep appending to the new file
+	f, err = os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.f.Close()
+	j.f = f
+	return nil
+}
+
+// marshalEntries encodes entries as lines of JSON.
+func marshalEntries(entries []journalEntry) ([]byte, error) {
+	var buf []byte
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+// syncDir fsyncs a directory, so that a rename in it survives a crash.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// entries reads back every entry in the journal. A torn last line, left behind
+// by a crash in the middle of a write, is skipped.
+func (j *journal) entries() ([]journalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var (
+		entries []journalEntry
+		r       = bufio.NewReader(j.f)
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var e journalEntry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return nil, fmt.Errorf("corrupt journal entry: %w", err)
+			}
+			entries = append(entries, e)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// replayJournal writes the URLs left in the journal by a previous run to the
+// database. Replaying is idempotent: rows that were already flushed are skipped.
+func (s *SQLStore) replayJournal() error {
+	entries, err := s.journal.entries()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Only the last operation on a short code matters.
+	var (
+		order  []string
+		latest = make(map[string]journalEntry)
+	)
+	for _, e := range entries {
+		if _, ok := latest[e.URL.ShortCode]; !ok {
+			order = append(order, e.URL.ShortCode)
+		}
+		latest[e.URL.ShortCode] = e
+	}
+
+	var (
+		puts []models.URLData
+		dels []string
+	)
+	for _, shortCode := range order {
+		e := latest[shortCode]
+		switch e.Op {
+		case journalPut:
+			e.URL.PasswordHash = e.PasswordHash
+			puts = append(puts, e.URL)
+		case journalDel:
+			dels = append(dels, shortCode)
+		}
+	}
+
+	// Conflicts are rows that were already flushed
+	if len(puts) > 0 {
+		if _, err := s.doFlush(puts); err != nil {
+			return fmt.Errorf("replay journal: %w", err)
+		}
+	}
+	for _, shortCode := range dels {
+		if _, err := s.db.Exec(s.rebind(`DELETE FROM urls WHERE short_code = ?`), shortCode); err != nil {
+			return fmt.Errorf("replay journal: %w", err)
+		}
+	}
+
+	s.logger.Info("replayed write journal", "urls", len(puts), "deleted", len(dels))
+
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+	return s.journal.truncate()
+}
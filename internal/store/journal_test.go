@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/lil/models"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestReplayJournal(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Conf{
+		Driver:        "sqlite",
+		DBPath:        filepath.Join(dir, "lil.db"),
+		BufferSize:    10,
+		FlushInterval: time.Hour,
+		Durability:    DurabilityJournal,
+		JournalPath:   filepath.Join(dir, "lil.journal"),
+	}
+
+	// A link that was flushed before the crash, and is written again by the
+	// replay
+	syncCfg := cfg
+	syncCfg.Durability = DurabilitySync
+	s, err := New(syncCfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateShortURL(context.Background(), models.URLData{URL: "https://flushed.example", ShortCode: "flushed", CreatedAt: time.Now()}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateShortURL(context.Background(), models.URLData{URL: "https://gone.example", ShortCode: "gone", CreatedAt: time.Now()}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(shortCode, target string) journalEntry {
+		return journalEntry{Op: journalPut, URL: models.URLData{URL: target, ShortCode: shortCode, CreatedAt: time.Now()}}
+	}
+	del := func(shortCode string) journalEntry {
+		return journalEntry{Op: journalDel, URL: models.URLData{ShortCode: shortCode}}
+	}
+	buf, err := marshalEntries([]journalEntry{
+		put("flushed", "https://flushed.example"),
+		put("new", "https://new.example"),
+		put("deleted", "https://deleted.example"),
+		del("deleted"),
+		del("gone"),
+		put("twice", "https://first.example"),
+		put("twice", "https://second.example"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A crash in the middle of a write leaves a torn last line
+	buf = append(buf, `{"op":"put","url":{"url":"https://torn`...)
+	if err := os.WriteFile(cfg.JournalPath, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = New(cfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		shortCode string
+		want      string
+	}{
+		{"flushed", "https://flushed.example"},
+		{"new", "https://new.example"},
+		{"deleted", ""},
+		{"gone", ""},
+		{"twice", "https://second.example"},
+	}
+	for _, tt := range tests {
+		urlData, err := s.GetURL(context.Background(), tt.shortCode)
+		switch {
+		case tt.want == "" && err != ErrNotExist:
+			t.Errorf("GetURL(%q) error = %v, want ErrNotExist", tt.shortCode, err)
+		case tt.want != "" && err != nil:
+			t.Errorf("GetURL(%q) error = %v", tt.shortCode, err)
+		case urlData.URL != tt.want:
+			t.Errorf("GetURL(%q) = %q, want %q", tt.shortCode, urlData.URL, tt.want)
+		}
+	}
+
+	if fi, err := os.Stat(cfg.JournalPath); err != nil || fi.Size() != 0 {
+		t.Errorf("journal wasn't emptied after the replay: %v", err)
+	}
+}
+
+func TestJournalFlushed(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "lil.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+
+	for _, shortCode := range []string{"a", "b", "c"} {
+		if err := j.append(journalEntry{Op: journalPut, URL: models.URLData{ShortCode: shortCode}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.append(journalEntry{Op: journalDel, URL: models.URLData{ShortCode: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the entries still pending are kept
+	if err := j.flushed("a"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := j.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].URL.ShortCode != "b" {
+		t.Errorf("entries after flushing a = %+v, want only b", entries)
+	}
+
+	// Appends go to the compacted journal
+	if err := j.append(journalEntry{Op: journalPut, URL: models.URLData{ShortCode: "d"}}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = j.entries(); err != nil || len(entries) != 2 {
+		t.Errorf("entries after appending d = %+v, %v, want b and d", entries, err)
+	}
+
+	if err := j.flushed("b", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = j.entries(); err != nil || len(entries) != 0 {
+		t.Errorf("entries after flushing everything = %+v, %v, want none", entries, err)
+	}
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mr-karan/lil/models"
+)
+
+// sortColumns maps the sort keys accepted by GetURLs to SQL expressions.
+var sortColumns = map[string]string{
+	models.SortCreatedAt: "u.created_at",
+	models.SortClicks:    "COALESCE(c.total, 0)",
+	models.SortTitle:     "COALESCE(u.title, '')",
+}
+
+// cursor is the decoded form of the opaque pagination cursor. It holds the
+// sort key and short code of the last URL on the previous page.
+type cursor struct {
+	Sort      string `json:"s"`
+	Desc      bool   `json:"d"`
+	Value     string `json:"v,omitempty"`
+	Num       int64  `json:"n,omitempty"`
+	ShortCode string `json:"c"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// GetURLs returns a page of URLs matching the query.
+func (s *SQLStore) GetURLs(ctx context.Context, q models.URLQuery) (models.URLPage, error) {
+	where, args := s.listFilters(q)
+
+	if _, ok := sortColumns[q.Sort]; !ok {
+		q.Sort = models.SortCreatedAt
+	}
+	sortCol := sortColumns[q.Sort]
+	// The raw value of the sort key is selected so it can be put in the cursor.
+	sortKey := sortCol
+	if q.Sort == models.SortCreatedAt {
+		sortKey = s.dialect.rawTime(sortCol)
+	}
+
+	order, cmp := "DESC", "<"
+	if !q.Desc {
+		order, cmp = "ASC", ">"
+	}
+
+	// Page through the results either by cursor or by offset.
+	pageWhere, pageArgs := where, append([]interface{}{}, args...)
+	var limitClause string
+	if q.Keyset {
+		if q.Cursor != "" {
+			c, err := decodeCursor(q.Cursor)
+			if err != nil || c.Sort != q.Sort || c.Desc != q.Desc {
+				return models.URLPage{}, ErrInvalidCursor
+			}
+
+			pageWhere += " AND (" + sortCol + ", u.short_code) " + cmp + " (?, ?)"
+			if q.Sort == models.SortClicks {
+				pageArgs = append(pageArgs, c.Num, c.ShortCode)
+			} else {
+				pageArgs = append(pageArgs, c.Value, c.ShortCode)
+			}
+		}
+		// Fetch one extra row to know if there's a next page
+		limitClause = "LIMIT ?"
+		pageArgs = append(pageArgs, q.PerPage+1)
+	} else {
+		limitClause = "LIMIT ? OFFSET ?"
+		pageArgs = append(pageArgs, q.PerPage, (q.Page-1)*q.PerPage)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT `+urlColumnList("u")+`,
+			COALESCE(c.total, 0), c.last_clicked_at, `+sortKey+`
+		FROM urls u
+		LEFT JOIN url_clicks c ON c.short_code = u.short_code
+		WHERE `+pageWhere+`
+		ORDER BY `+sortCol+` `+order+`, u.short_code `+order+`
+		`+limitClause),
+		pageArgs...)
+	if err != nil {
+		return models.URLPage{}, err
+	}
+	defer rows.Close()
+
+	var (
+		page    = models.URLPage{URLs: []models.URLData{}}
+		lastKey interface{}
+	)
+	for rows.Next() {
+		var (
+			clicks        int64
+			lastClickedAt sql.NullTime
+			key           interface{}
+		)
+		urlData, err := scanURL(rows, &clicks, &lastClickedAt, &key)
+		if err != nil {
+			return models.URLPage{}, err
+		}
+		urlData.Clicks = clicks
+		if lastClickedAt.Valid {
+			urlData.LastClickedAt = &lastClickedAt.Time
+		}
+
+		if q.Keyset && int64(len(page.URLs)) == q.PerPage {
+			// This is the extra row, so there's another page after this one
+			page.NextCursor = newCursor(q, page.URLs[len(page.URLs)-1].ShortCode, lastKey).encode()
+			break
+		}
+		page.URLs = append(page.URLs, urlData)
+		lastKey = key
+	}
+	if err := rows.Err(); err != nil {
+		return models.URLPage{}, err
+	}
+	rows.Close()
+	s.addPendingClicks(page.URLs)
+
+	if !q.Keyset || q.WithCount {
+		var total int64
+		err = s.db.QueryRowContext(ctx,
+			s.rebind(`SELECT COUNT(*) FROM urls u WHERE `+where), args...).Scan(&total)
+		if err != nil {
+			return models.URLPage{}, err
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// newCursor builds the cursor pointing after the URL with the given short code and raw sort key.
+func newCursor(q models.URLQuery, shortCode string, key interface{}) cursor {
+	c := cursor{Sort: q.Sort, Desc: q.Desc, ShortCode: shortCode}
+
+	switch v := key.(type) {
+	case int64:
+		c.Num = v
+	case string:
+		c.Value = v
+	case []byte:
+		c.Value = string(v)
+	case time.Time:
+		c.Value = v.Format(time.RFC3339Nano)
+	}
+
+	return c
+}
+
+// listFilters builds the WHERE clause (against the `urls u` table) for a listing query.
+func (s *SQLStore) listFilters(q models.URLQuery) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+		now   = time.Now()
+	)
+
+	const live = "(u.expires_at IS NULL OR u.expires_at > ?) AND (u.remaining_clicks IS NULL OR u.remaining_clicks > 0)"
+	switch {
+	case q.Expired:
+		conds = append(conds, "((u.expires_at IS NOT NULL AND u.expires_at <= ?) OR u.remaining_clicks <= 0)")
+		args = append(args, now)
+	case q.Scheduled:
+		conds = append(conds, live, "u.active_from IS NOT NULL AND u.active_from > ?")
+		args = append(args, now, now)
+	default:
+		conds = append(conds, live, "(u.active_from IS NULL OR u.active_from <= ?)")
+		args = append(args, now, now)
+	}
+
+	if q.WithVariants {
+		conds = append(conds, "u.variants <> ''")
+	}
+	if q.Campaign != "" {
+		conds = append(conds, "u.utm_campaign = ?")
+		args = append(args, q.Campaign)
+	}
+	if q.ExpiringBefore != nil {
+		conds = append(conds, "u.expires_at IS NOT NULL AND u.expires_at < ?")
+		args = append(args, *q.ExpiringBefore)
+	}
+	if q.CreatedAfter != nil {
+		conds = append(conds, "u.created_at > ?")
+		args = append(args, *q.CreatedAfter)
+	}
+	if search := strings.TrimSpace(q.Search); search != "" {
+		cond, searchArgs := s.dialect.search(search)
+		conds = append(conds, cond)
+		args = append(args, searchArgs...)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationsFS holds the schema migrations of every dialect, in
+// migrations/<dialect>/<version>_<name>.sql files.
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// Migration is a versioned schema change. Migrations are applied in order of
+// their version, each in its own transaction, and recorded in the
+// schema_migrations table.
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+// loadMigrations reads the embedded migrations of a dialect, sorted by version.
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := path.Join("migrations", d.name)
+	files, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		out  []Migration
+		seen = make(map[int]string)
+	)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".sql") {
+			continue
+		}
+
+		num, _, ok := strings.Cut(f.Name(), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration file name: %s", f.Name())
+		}
+		if prev, ok := seen[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, prev, f.Name())
+		}
+		seen[version] = f.Name()
+
+		b, err := migrationsFS.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Migration{
+			Version: version,
+			Name:    strings.TrimSuffix(f.Name(), ".sql"),
+			sql:     string(b),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// migrate brings the schema up to date and returns the migrations that were
+// applied. With dryRun set, nothing is changed and the pending migrations are
+// returned instead.
+func migrate(db *sql.DB, d dialect, dryRun bool, logger *slog.Logger) ([]Migration, error) {
+	migrations, err := loadMigrations(d)
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Migration
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if dryRun {
+			out = append(out, m)
+			continue
+		}
+
+		ok, err := applyMigration(db, d, m)
+		if err != nil {
+			return out, fmt.Errorf("apply migration %s: %w", m.Name, err)
+		}
+		if ok {
+			logger.Info("applied migration", "version", m.Version, "name", m.Name)
+			out = append(out, m)
+		}
+	}
+
+	return out, nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations.
+func appliedMigrations(db *sql.DB) (map[int]bool, error) {
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// applyMigration runs a migration and records it. It reports false if the
+// migration was already applied by another instance in the meantime.
+func applyMigration(db *sql.DB, d dialect, m Migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if d.migrationLock != "" {
+		if _, err := tx.Exec(d.migrationLock); err != nil {
+			return false, fmt.Errorf("lock: %w", err)
+		}
+	}
+
+	var n int
+	if err := tx.QueryRow(d.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), m.Version).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(d.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+		m.Version, m.Name, time.Now().UTC()); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Migrate opens the configured database, applies the pending schema
+// migrations (or only lists them if dryRun is set) and closes it again.
+func Migrate(cfg Conf, dryRun bool, logger *slog.Logger) ([]Migration, error) {
+	d, ok := dialects[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown db driver: %s", cfg.Driver)
+	}
+
+	db, err := sql.Open(d.driver, d.dsn(cfg))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if err := applyPragmas(db, d); err != nil {
+		return nil, err
+	}
+	return migrate(db, d, dryRun, logger)
+}
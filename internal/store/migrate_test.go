@@ -0,0 +1,77 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	versions := map[string][]int{}
+	for name, d := range dialects {
+		migrations, err := loadMigrations(d)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("%s: migration %s has version %d, want %d", name, m.Name, m.Version, i+1)
+			}
+			versions[name] = append(versions[name], m.Version)
+		}
+	}
+
+	// Both dialects have to be at the same schema version
+	if len(versions["sqlite"]) != len(versions["postgres"]) {
+		t.Errorf("sqlite has %d migrations, postgres %d", len(versions["sqlite"]), len(versions["postgres"]))
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	cfg := Conf{
+		Driver:        "sqlite",
+		DBPath:        filepath.Join(t.TempDir(), "lil.db"),
+		BufferSize:    10,
+		FlushInterval: time.Hour,
+	}
+	all, err := loadMigrations(dialects["sqlite"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := Migrate(cfg, true, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(all) {
+		t.Fatalf("dry run lists %d migrations, want %d", len(pending), len(all))
+	}
+
+	applied, err := Migrate(cfg, false, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(all))
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		again, err := Migrate(cfg, dryRun, discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(again) != 0 {
+			t.Errorf("Migrate(dryRun=%v) on an up to date database returned %d migrations", dryRun, len(again))
+		}
+	}
+
+	// The migrated schema has every column the store reads
+	s, err := New(cfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.(*SQLStore).db.Exec(`SELECT ` + urlColumnList("") + ` FROM urls`); err != nil {
+		t.Errorf("select url columns: %v", err)
+	}
+}
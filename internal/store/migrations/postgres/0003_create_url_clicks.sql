@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS url_clicks (
+    short_code TEXT PRIMARY KEY,
+    total BIGINT NOT NULL DEFAULT 0,
+    last_clicked_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS url_clicks_daily (
+    short_code TEXT NOT NULL,
+    day TEXT NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_code, day)
+);
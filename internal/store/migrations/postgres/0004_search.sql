@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls (created_at);
+CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls (expires_at);
+CREATE INDEX IF NOT EXISTS idx_urls_title ON urls (title);
+CREATE INDEX IF NOT EXISTS idx_url_clicks_total ON url_clicks (total);
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Expired links with an expired_redirect_url are kept and redirect there instead.
+ALTER TABLE urls ADD COLUMN expired_redirect_url TEXT NOT NULL DEFAULT '';
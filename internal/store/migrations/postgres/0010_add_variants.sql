@@ -0,0 +1,3 @@
+-- variants holds the JSON encoded weighted targets of A/B links, '' for plain links.
+ALTER TABLE urls ADD COLUMN variants TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN sticky_variants BOOLEAN NOT NULL DEFAULT FALSE;
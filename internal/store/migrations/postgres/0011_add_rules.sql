@@ -0,0 +1,2 @@
+-- rules holds the JSON encoded targeting rules of a link, '' if it has none.
+ALTER TABLE urls ADD COLUMN rules TEXT NOT NULL DEFAULT '';
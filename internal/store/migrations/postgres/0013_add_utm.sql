@@ -0,0 +1,7 @@
+-- The UTM parameters a link was created with, kept apart from the URL for filtering.
+ALTER TABLE urls ADD COLUMN utm_source TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN utm_medium TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN utm_campaign TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN utm_term TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN utm_content TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_urls_utm_campaign ON urls (utm_campaign);
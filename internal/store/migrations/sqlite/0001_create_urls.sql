@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS urls (
+    short_code TEXT PRIMARY KEY,
+    url TEXT NOT NULL,
+    title TEXT,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME
+);
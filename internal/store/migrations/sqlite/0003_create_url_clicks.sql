@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS url_clicks (
+    short_code TEXT PRIMARY KEY,
+    total INTEGER NOT NULL DEFAULT 0,
+    last_clicked_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS url_clicks_daily (
+    short_code TEXT NOT NULL,
+    day TEXT NOT NULL,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_code, day)
+);
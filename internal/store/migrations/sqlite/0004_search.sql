@@ -0,0 +1,57 @@
+CREATE INDEX IF NOT EXISTS idx_url_clicks_total ON url_clicks (total);
+
+-- The full-text index needs an integer key for its rows. The implicit rowid of
+-- urls won't do, as VACUUM may renumber it while short_code is the primary
+-- key, so urls is rebuilt with an explicit INTEGER PRIMARY KEY, which VACUUM
+-- keeps. Copying the columns by name is safe: migrations run in order, so here
+-- urls has exactly the columns of 0001, and later migrations add theirs to the
+-- rebuilt table.
+DROP TRIGGER IF EXISTS urls_fts_insert;
+DROP TRIGGER IF EXISTS urls_fts_delete;
+DROP TRIGGER IF EXISTS urls_fts_update;
+DROP TABLE IF EXISTS urls_fts;
+
+CREATE TABLE urls_new (
+    id INTEGER PRIMARY KEY,
+    short_code TEXT NOT NULL UNIQUE,
+    url TEXT NOT NULL,
+    title TEXT,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME
+);
+
+INSERT INTO urls_new (id, short_code, url, title, created_at, expires_at)
+SELECT rowid, short_code, url, title, created_at, expires_at FROM urls;
+
+DROP TABLE urls;
+ALTER TABLE urls_new RENAME TO urls;
+
+CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls (created_at);
+CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls (expires_at);
+CREATE INDEX IF NOT EXISTS idx_urls_title ON urls (title);
+
+-- Full-text index over the urls table, kept in sync by triggers.
+CREATE VIRTUAL TABLE urls_fts USING fts5(
+    short_code, url, title,
+    content = 'urls', content_rowid = 'id'
+);
+
+CREATE TRIGGER urls_fts_insert AFTER INSERT ON urls BEGIN
+    INSERT INTO urls_fts (rowid, short_code, url, title)
+    VALUES (new.id, new.short_code, new.url, new.title);
+END;
+
+CREATE TRIGGER urls_fts_delete AFTER DELETE ON urls BEGIN
+    INSERT INTO urls_fts (urls_fts, rowid, short_code, url, title)
+    VALUES ('delete', old.id, old.short_code, old.url, old.title);
+END;
+
+CREATE TRIGGER urls_fts_update AFTER UPDATE ON urls BEGIN
+    INSERT INTO urls_fts (urls_fts, rowid, short_code, url, title)
+    VALUES ('delete', old.id, old.short_code, old.url, old.title);
+    INSERT INTO urls_fts (rowid, short_code, url, title)
+    VALUES (new.id, new.short_code, new.url, new.title);
+END;
+
+-- Index the rows that existed before the table was created.
+INSERT INTO urls_fts (urls_fts) VALUES ('rebuild');
@@ -0,0 +1,3 @@
+-- max_clicks is 0 and remaining_clicks NULL for links without a click limit.
+ALTER TABLE urls ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN remaining_clicks INTEGER;
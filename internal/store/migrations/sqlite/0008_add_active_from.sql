@@ -0,0 +1,3 @@
+-- active_from is NULL for links that resolve as soon as they're created.
+ALTER TABLE urls ADD COLUMN active_from DATETIME;
+CREATE INDEX IF NOT EXISTS idx_urls_active_from ON urls (active_from);
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN interstitial INTEGER NOT NULL DEFAULT 0;
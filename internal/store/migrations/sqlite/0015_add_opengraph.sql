@@ -0,0 +1,4 @@
+-- The preview card shown to the crawlers of social networks and chats.
+ALTER TABLE urls ADD COLUMN og_title TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN og_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN og_image TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Metadata fetched from the target page of a link.
+ALTER TABLE urls ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN favicon TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+var postgresDialect = dialect{
+	name:   "postgres",
+	driver: "pgx",
+	// Serializes migrations across instances sharing the database.
+	migrationLock:  `SELECT pg_advisory_xact_lock(7015723)`,
+	search:         postgresSearch,
+	rawTime:        func(col string) string { return col },
+	numberedParams: true,
+}
+
+// postgresSearch does a case-insensitive substring match of the term against
+// the short code, URL and title.
+func postgresSearch(term string) (string, []interface{}) {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	pattern := "%" + r.Replace(term) + "%"
+
+	return `(u.short_code ILIKE ? OR u.url ILIKE ? OR u.title ILIKE ?)`,
+		[]interface{}{pattern, pattern, pattern}
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	_ "embed"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed pragmas.sql
+var pragmas string
+
+var sqliteDialect = dialect{
+	name:    "sqlite",
+	driver:  "sqlite",
+	pragmas: pragmas,
+	search:  sqliteSearch,
+	// Timestamps are stored as text. Without a declared column type, the
+	// driver doesn't parse them into a time.Time.
+	rawTime: func(col string) string { return "CAST(" + col + " AS TEXT)" },
+}
+
+// sqliteSearch matches every word of the term as a prefix against the FTS index.
+func sqliteSearch(term string) (string, []interface{}) {
+	words := strings.Fields(term)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"*`
+	}
+
+	return `u.id IN (SELECT rowid FROM urls_fts WHERE urls_fts MATCH ?)`,
+		[]interface{}{strings.Join(words, " ")}
+}
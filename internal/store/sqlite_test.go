@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/lil/models"
+)
+
+func TestSearchAfterVacuum(t *testing.T) {
+	ctx := context.Background()
+	cfg := Conf{
+		Driver:        "sqlite",
+		DBPath:        filepath.Join(t.TempDir(), "lil.db"),
+		BufferSize:    10,
+		FlushInterval: time.Hour,
+		Durability:    DurabilitySync,
+	}
+
+	// A database from before migrations, whose links are indexed by 0004
+	db, err := sql.Open("sqlite", cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE urls (
+		short_code TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		title TEXT,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, shortCode := range []string{"alpha", "bravo", "charlie"} {
+		if _, err := db.Exec(`INSERT INTO urls (short_code, url, created_at) VALUES (?, ?, ?)`,
+			shortCode, "https://"+shortCode+".example", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := New(cfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, shortCode := range []string{"delta", "echo"} {
+		urlData := models.URLData{URL: "https://" + shortCode + ".example", ShortCode: shortCode, CreatedAt: time.Now()}
+		if _, err := s.CreateShortURL(ctx, urlData, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, shortCode := range []string{"alpha", "delta"} {
+		if err := s.DeleteURL(ctx, shortCode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.(*SQLStore).db.Exec(`VACUUM`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, term := range []string{"bravo", "charlie", "echo"} {
+		res, err := s.GetURLs(ctx, models.URLQuery{Search: term, Page: 1, PerPage: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.URLs) != 1 || res.URLs[0].ShortCode != term {
+			t.Errorf("search for %q found %+v", term, res.URLs)
+		}
+	}
+	for _, term := range []string{"alpha", "delta"} {
+		res, err := s.GetURLs(ctx, models.URLQuery{Search: term, Page: 1, PerPage: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.URLs) != 0 {
+			t.Errorf("search for deleted %q found %+v", term, res.URLs)
+		}
+	}
+}
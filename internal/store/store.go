@@ -3,7 +3,6 @@ package store
 import (
 	"context"
 	"database/sql"
-	_ "embed"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -14,43 +13,155 @@ import (
 
 	"github.com/mr-karan/lil/internal/metrics"
 	"github.com/mr-karan/lil/models"
-	_ "modernc.org/sqlite"
 )
 
-//go:embed pragmas.sql
-var pragmas string
-
 var ErrNotExist = errors.New("the URL does not exist")
 
-type Store struct {
+// ErrSlugExists is returned when a custom slug is already taken.
+var ErrSlugExists = errors.New("slug already exists")
+
+// ErrExpired is returned, along with the URL, for an expired URL that has an
+// ExpiredRedirectURL to send visitors to instead.
+var ErrExpired = errors.New("the URL has expired")
+
+// ErrNotActive is returned when a URL is scheduled to become active later.
+var ErrNotActive = errors.New("the URL is not active yet")
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded or
+// doesn't match the requested sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Durability modes control when a newly created URL is considered persisted.
+const (
+	// DurabilityBuffered acknowledges URLs as soon as they're in the write
+	// buffer. Fastest, but a crash loses anything not yet flushed.
+	DurabilityBuffered = "buffered"
+	// DurabilitySync writes every URL to the database before acknowledging it.
+	DurabilitySync = "sync"
+	// DurabilityJournal appends every URL to an fsynced on-disk journal before
+	// acknowledging it and flushes to the database in batches. The journal is
+	// replayed on startup.
+	DurabilityJournal = "journal"
+)
+
+// Store is the storage backend used by the HTTP handlers.
+type Store interface {
+	Ping(ctx context.Context) error
+	Close() error
+
+	CreateShortURL(ctx context.Context, urlData models.URLData, expiry time.Duration) (string, error)
+	CreateShortURLs(ctx context.Context, urls []models.URLData) []map[string]string
+	GetRedirectData(ctx context.Context, shortCode string) (models.URLData, error)
+	GetURL(ctx context.Context, shortCode string) (models.URLData, error)
+	UpdateURL(ctx context.Context, shortCode string, upd models.URLUpdate) (models.URLData, error)
+	FillMetadata(ctx context.Context, shortCode, title, description, favicon string) error
+	DeleteURL(ctx context.Context, shortCode string) error
+	GetURLs(ctx context.Context, q models.URLQuery) (models.URLPage, error)
+	StartExpiryWorker(ctx context.Context)
+
+	RecordClick(shortCode string, at time.Time)
+	ConsumeClick(ctx context.Context, shortCode string) (int64, error)
+	GetStats(ctx context.Context, shortCode string, days int) (models.URLStats, error)
+
+	CreateAPIKey(ctx context.Context, name string, scopes []string) (models.APIKey, string, error)
+	GetAPIKeyByToken(ctx context.Context, token string) (models.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+// SQLStore is a Store backed by a database/sql database (SQLite or PostgreSQL),
+// fronted by an in-memory cache and a batched write buffer.
+type SQLStore struct {
 	db          *sql.DB
-	cache       map[string]models.URLData
+	dialect     dialect
+	cache       urlCache
 	mu          sync.RWMutex
 	logger      *slog.Logger
 	shortURLLen int
 
+	// cacheGen is bumped whenever a URL is updated or deleted, so that a
+	// concurrent read-through doesn't cache a stale copy.
+	cacheGen uint64
+	// reserved holds the short codes of URLs that are being created, so that
+	// concurrent creates can't claim them while they're persisted.
+	reserved map[string]struct{}
+
 	// Write buffer components
-	writeBuf    []models.URLData
-	bufMu       sync.Mutex
+	writeBuf []models.URLData
+	bufMu    sync.Mutex
+	pending  map[string]models.URLData // Every URL accepted but not yet flushed, including batches in flight
+	// skip holds the short codes of queued batches that must not be inserted
+	// after all, because they've been deleted, or written by UpdateURL, in the
+	// meantime.
+	skip map[string]struct{}
+	// flushMu is held while a batch is inserted, so that updates and deletes
+	// of the URLs in it wait until it's done instead of racing with it.
+	flushMu     sync.Mutex
 	bufferSize  int
 	flushTicker *time.Ticker
 	done        chan struct{}
 	flushChan   chan []models.URLData
 	workerDone  chan struct{}
+	durability  string
+	journal     *journal
+
+	// Click counters accumulated in memory between flushes
+	clicks   map[string]*clickCounter
+	clicksMu sync.Mutex
 }
 
 type Conf struct {
-	DBPath              string
+	Driver              string // "sqlite" (default) or "postgres"
+	DBPath              string // SQLite database file
+	DSN                 string // PostgreSQL connection string
 	MaxOpenConns        int
 	MaxIdleConns        int
 	ConnMaxLifetimeMins int
 	ShortURLLength      int
 	BufferSize          int // Number of URLs to buffer before flush
 	FlushInterval       time.Duration
+	Durability          string // One of the Durability* modes, defaults to buffered
+	JournalPath         string // Write journal file, used by DurabilityJournal
+	CacheMode           string // One of the Cache* modes, defaults to full
+	CacheSize           int    // Maximum number of cached URLs, used by CacheLRU
+	NegativeCacheSize   int    // Maximum number of remembered unknown short codes, 0 disables negative caching
+	NegativeCacheTTL    time.Duration
 }
 
-func New(cfg Conf, logger *slog.Logger) (*Store, error) {
-	db, err := sql.Open("sqlite", cfg.DBPath)
+// New opens the database for the configured driver and returns a ready to use Store.
+func New(cfg Conf, logger *slog.Logger) (Store, error) {
+	d, ok := dialects[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown db driver: %s", cfg.Driver)
+	}
+
+	switch cfg.Durability {
+	case "":
+		cfg.Durability = DurabilityBuffered
+	case DurabilityBuffered, DurabilitySync, DurabilityJournal:
+	default:
+		return nil, fmt.Errorf("unknown durability mode: %s", cfg.Durability)
+	}
+
+	var cache urlCache
+	switch cfg.CacheMode {
+	case "", CacheFull:
+		cfg.CacheMode = CacheFull
+		cache = make(fullCache)
+	case CacheLRU:
+		if cfg.CacheSize <= 0 {
+			cfg.CacheSize = defaultCacheSize
+		}
+		c, err := newLRUCache(cfg.CacheSize, cfg.NegativeCacheSize, cfg.NegativeCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		cache = c
+	default:
+		return nil, fmt.Errorf("unknown cache mode: %s", cfg.CacheMode)
+	}
+
+	db, err := sql.Open(d.driver, d.dsn(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -60,109 +171,152 @@ func New(cfg Conf, logger *slog.Logger) (*Store, error) {
 	db.SetMaxIdleConns(cfg.MaxIdleConns)
 	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetimeMins) * time.Minute)
 
-	// Create tables if they don't exist
-	if err := initDB(db); err != nil {
+	// Bring the schema up to date
+	if err := applyPragmas(db, d); err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, d, false, logger); err != nil {
 		return nil, err
 	}
 
-	s := &Store{
+	s := &SQLStore{
 		db:          db,
-		cache:       make(map[string]models.URLData),
+		dialect:     d,
+		cache:       cache,
 		logger:      logger,
 		shortURLLen: cfg.ShortURLLength,
 		bufferSize:  cfg.BufferSize,
 		writeBuf:    make([]models.URLData, 0, cfg.BufferSize),
+		pending:     make(map[string]models.URLData),
+		skip:        make(map[string]struct{}),
+		reserved:    make(map[string]struct{}),
 		flushTicker: time.NewTicker(cfg.FlushInterval),
 		done:        make(chan struct{}),
 		flushChan:   make(chan []models.URLData, 100), // Buffer channel for pending flushes
 		workerDone:  make(chan struct{}),
+		durability:  cfg.Durability,
+		clicks:      make(map[string]*clickCounter),
 	}
 
-	// Start single flush worker
-	go s.flushWorker()
+	// Write back anything acknowledged but not flushed before the last shutdown
+	if cfg.Durability == DurabilityJournal {
+		j, err := openJournal(cfg.JournalPath)
+		if err != nil {
+			return nil, err
+		}
+		s.journal = j
 
-	// Load all existing URLs into cache
-	if err := s.loadCache(); err != nil {
-		return nil, err
+		if err := s.replayJournal(); err != nil {
+			return nil, err
+		}
 	}
 
-	// Initialize URLs stored gauge
-	metrics.URLsStoredGauge.Set(float64(len(s.cache)))
-
-	return s, nil
-}
-
-func initDB(db *sql.DB) error {
-	// Create tables
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS urls (
-			short_code TEXT PRIMARY KEY,
-			url TEXT NOT NULL,
-			title TEXT,
-			created_at DATETIME NOT NULL,
-			expires_at DATETIME
-		)
-	`); err != nil {
-		return err
-	}
+	// Start single flush worker
+	go s.flushWorker()
 
-	// Apply PRAGMA statements
-	if _, err := db.Exec(pragmas); err != nil {
-		return err
+	// Load all existing URLs into cache, or just count them if they're read on demand
+	if cfg.CacheMode == CacheFull {
+		if err := s.loadCache(); err != nil {
+			return nil, err
+		}
+	} else {
+		var count int64
+		if err := db.QueryRow(`SELECT COUNT(*) FROM urls`).Scan(&count); err != nil {
+			return nil, err
+		}
+		metrics.URLsStoredGauge.Set(float64(count))
 	}
 
-	return nil
+	return s, nil
 }
 
-func (s *Store) loadCache() error {
-	rows, err := s.db.Query(`SELECT short_code, url, title, created_at, expires_at FROM urls`)
+// loadCache reads every URL into the cache.
+func (s *SQLStore) loadCache() error {
+	rows, err := s.db.Query(`SELECT ` + urlColumnList("") + ` FROM urls`)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
+	var count int
 	for rows.Next() {
-		var urlData models.URLData
-		var expiresAt sql.NullTime
-		err := rows.Scan(&urlData.ShortCode, &urlData.URL, &urlData.Title, &urlData.CreatedAt, &expiresAt)
+		urlData, err := scanURL(rows)
 		if err != nil {
 			return err
 		}
-		if expiresAt.Valid {
-			urlData.ExpiresAt = &expiresAt.Time
-		}
-		s.cache[urlData.ShortCode] = urlData
+		s.cache.set(urlData)
+		count++
 	}
+	metrics.URLsStoredGauge.Set(float64(count))
 	return rows.Err()
 }
 
-func (s *Store) Close() error {
+// Close stops the flush worker, writes out everything still buffered in memory
+// and closes the database.
+func (s *SQLStore) Close() error {
 	s.flushTicker.Stop()
 	close(s.done)
-	close(s.flushChan)
 	<-s.workerDone // Wait for worker to finish
-	return s.db.Close()
+
+	// Flush whatever is left in the write buffer and the click counters. A
+	// batch that fails is requeued into the buffer, so it's retried from there
+	// a few times, and reported if it still can't be written.
+	const maxCloseFlushes = 3
+	for i := 0; i < maxCloseFlushes; i++ {
+		s.bufMu.Lock()
+		urls := s.writeBuf
+		s.writeBuf = nil
+		s.bufMu.Unlock()
+		if len(urls) == 0 {
+			break
+		}
+		s.flushWithRetry(urls)
+	}
+	var unflushed error
+	s.bufMu.Lock()
+	if len(s.writeBuf) > 0 {
+		shortCodes := make([]string, len(s.writeBuf))
+		for i, urlData := range s.writeBuf {
+			shortCodes[i] = urlData.ShortCode
+		}
+		unflushed = fmt.Errorf("failed to write %d buffered urls: %s", len(shortCodes), strings.Join(shortCodes, ", "))
+	}
+	s.bufMu.Unlock()
+
+	s.flushClicks()
+	if s.journal != nil {
+		if err := s.journal.close(); err != nil {
+			s.logger.Error("failed to close journal", "error", err)
+		}
+	}
+	return errors.Join(unflushed, s.db.Close())
 }
 
-func (s *Store) flushWorker() {
+func (s *SQLStore) flushWorker() {
 	defer close(s.workerDone)
 
 	for {
 		select {
 		case <-s.flushTicker.C:
 			s.triggerFlush()
-		case urls, ok := <-s.flushChan:
-			if !ok {
-				return
-			}
+			s.flushClicks()
+		case urls := <-s.flushChan:
 			s.flushWithRetry(urls)
 		case <-s.done:
-			return
+			// Drain batches that are already queued before exiting
+			for {
+				select {
+				case urls := <-s.flushChan:
+					s.flushWithRetry(urls)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-func (s *Store) triggerFlush() {
+func (s *SQLStore) triggerFlush() {
 	s.bufMu.Lock()
 	if len(s.writeBuf) == 0 {
 		s.bufMu.Unlock()
@@ -179,16 +333,44 @@ func (s *Store) triggerFlush() {
 	select {
 	case s.flushChan <- urls:
 	default:
-		s.logger.Warn("flush channel full, dropping batch", "count", len(urls))
+		s.logger.Warn("flush channel full, requeueing batch", "count", len(urls))
+		s.requeue(urls)
 	}
 }
 
-func (s *Store) flushWithRetry(urls []models.URLData) {
+// requeue puts a batch that couldn't be flushed back at the front of the write
+// buffer so that it's retried on the next flush instead of being lost.
+func (s *SQLStore) requeue(urls []models.URLData) {
+	s.bufMu.Lock()
+	s.writeBuf = append(urls, s.writeBuf...)
+	s.bufMu.Unlock()
+}
+
+func (s *SQLStore) flushWithRetry(urls []models.URLData) {
+	// The cache is fixed up once the batch is done, as it's guarded by s.mu,
+	// which UpdateURL holds while waiting for s.flushMu
+	if conflicts := s.insertBatch(urls); len(conflicts) > 0 {
+		s.dropConflicts(conflicts)
+	}
+}
+
+// insertBatch writes a batch to the database, retrying a few times before
+// requeueing it, and returns the short codes that turned out to be taken.
+func (s *SQLStore) insertBatch(urls []models.URLData) []string {
 	const maxRetries = 3
 	const retryDelay = 100 * time.Millisecond
 
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	urls = s.unskipped(urls)
+	if len(urls) == 0 {
+		return nil
+	}
+
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		if err := s.doFlush(urls); err != nil {
+		conflicts, err := s.doFlush(urls)
+		if err != nil {
 			if attempt < maxRetries-1 {
 				s.logger.Warn("flush failed, retrying",
 					"error", err,
@@ -197,143 +379,396 @@ func (s *Store) flushWithRetry(urls []models.URLData) {
 				time.Sleep(retryDelay * time.Duration(attempt+1))
 				continue
 			}
-			s.logger.Error("flush failed after retries",
+			s.logger.Error("flush failed after retries, requeueing batch",
 				"error", err,
 				"count", len(urls))
+			s.requeue(urls)
+			return nil
 		}
-		return
+
+		s.logger.Info("flushed urls to database", "count", len(urls))
+		s.bufMu.Lock()
+		for _, urlData := range urls {
+			delete(s.pending, urlData.ShortCode)
+		}
+		s.bufMu.Unlock()
+		if s.journal != nil {
+			shortCodes := make([]string, len(urls))
+			for i, urlData := range urls {
+				shortCodes[i] = urlData.ShortCode
+			}
+			if err := s.journal.flushed(shortCodes...); err != nil {
+				s.logger.Error("failed to compact journal", "error", err)
+			}
+		}
+		return conflicts
 	}
+	return nil
 }
 
-func (s *Store) doFlush(urls []models.URLData) error {
+// unskipped returns the URLs of a batch that still have to be inserted.
+func (s *SQLStore) unskipped(urls []models.URLData) []models.URLData {
+	s.bufMu.Lock()
+	defer s.bufMu.Unlock()
+
+	if len(s.skip) == 0 {
+		return urls
+	}
+	kept := urls[:0:0]
+	for _, urlData := range urls {
+		if _, ok := s.skip[urlData.ShortCode]; ok {
+			delete(s.skip, urlData.ShortCode)
+			continue
+		}
+		kept = append(kept, urlData)
+	}
+	return kept
+}
+
+// dropConflicts handles buffered URLs whose short code turned out to be taken
+// when they were flushed, e.g. by another instance sharing the database. They
+// can't be saved, so the cache is pointed at the row that's in the database.
+func (s *SQLStore) dropConflicts(shortCodes []string) {
+	s.logger.Error("dropped buffered urls whose short code is already taken", "shortCodes", shortCodes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shortCode := range shortCodes {
+		urlData, ok, err := s.fetch(context.Background(), shortCode)
+		if err != nil || !ok {
+			s.cache.remove(shortCode)
+		} else {
+			s.cache.set(urlData)
+		}
+		metrics.URLsStoredGauge.Dec()
+	}
+	s.cacheGen++
+}
+
+// maxQueryParams caps the number of bound parameters in a single statement,
+// below the limits of both SQLite (32766) and PostgreSQL (65535).
+const maxQueryParams = 30000
+
+// doFlush inserts a batch of URLs and returns the short codes of those that
+// weren't inserted because their short code is already taken.
+func (s *SQLStore) doFlush(urls []models.URLData) ([]string, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+		return nil, fmt.Errorf("begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Build a single INSERT statement with multiple VALUES clauses
-	var sb strings.Builder
-	sb.WriteString(`INSERT INTO urls (short_code, url, title, created_at, expires_at) VALUES `)
+	inserted := make(map[string]bool, len(urls))
 
-	vals := make([]interface{}, 0, len(urls)*5) // 5 fields per URL
+	// Insert in as few multi-row statements as the parameter limit allows
+	chunkSize := maxQueryParams / len(urlColumns)
+	for start := 0; start < len(urls); start += chunkSize {
+		chunk := urls[start:min(start+chunkSize, len(urls))]
 
-	for i, urlData := range urls {
-		if i > 0 {
-			sb.WriteString(",")
+		var sb strings.Builder
+		sb.WriteString(`INSERT INTO urls (` + urlColumnList("") + `) VALUES `)
+
+		vals := make([]interface{}, 0, len(chunk)*len(urlColumns))
+		for i, urlData := range chunk {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(urlPlaceholders())
+			vals = append(vals, urlArgs(urlData)...)
 		}
-		sb.WriteString("(?,?,?,?,?)")
 
-		vals = append(vals,
-			urlData.ShortCode,
-			urlData.URL,
-			urlData.Title,
-			urlData.CreatedAt,
-			urlData.ExpiresAt,
-		)
-	}
+		// Never overwrite an existing row. The rows that weren't inserted are
+		// told apart by the short codes returned.
+		sb.WriteString(" ON CONFLICT(short_code) DO NOTHING RETURNING short_code")
 
-	// Execute single batch insert
-	if _, err := tx.Exec(sb.String(), vals...); err != nil {
-		return fmt.Errorf("batch insert: %w", err)
+		rows, err := tx.Query(s.rebind(sb.String()), vals...)
+		if err != nil {
+			return nil, fmt.Errorf("batch insert: %w", err)
+		}
+		for rows.Next() {
+			var shortCode string
+			if err := rows.Scan(&shortCode); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("batch insert: %w", err)
+			}
+			inserted[shortCode] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("batch insert: %w", err)
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit transaction: %w", err)
+		return nil, fmt.Errorf("commit transaction: %w", err)
 	}
 
-	s.logger.Info("flushed urls to database", "count", len(urls))
-	return nil
+	var conflicts []string
+	for _, urlData := range urls {
+		if !inserted[urlData.ShortCode] {
+			conflicts = append(conflicts, urlData.ShortCode)
+		}
+	}
+	return conflicts, nil
 }
 
-func (s *Store) Ping(ctx context.Context) error {
-	return s.db.PingContext(ctx)
+// persist hands newly created URLs over to the database according to the
+// configured durability mode. Once it returns without an error the URLs are
+// guaranteed to be written eventually (except in the buffered mode). URLs that
+// are written right away and turn out to have a taken short code are returned
+// as conflicts instead.
+func (s *SQLStore) persist(urls []models.URLData) ([]string, error) {
+	// Click-limited URLs are always written through, as their remaining clicks
+	// are counted down in the database.
+	var (
+		limited, unlimited []models.URLData
+		conflicts          []string
+	)
+	for _, urlData := range urls {
+		if urlData.MaxClicks > 0 {
+			limited = append(limited, urlData)
+		} else {
+			unlimited = append(unlimited, urlData)
+		}
+	}
+	if len(limited) > 0 {
+		c, err := s.doFlush(limited)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = c
+	}
+	urls = unlimited
+	if len(urls) == 0 {
+		return conflicts, nil
+	}
+
+	switch s.durability {
+	case DurabilitySync:
+		c, err := s.doFlush(urls)
+		if err != nil {
+			return nil, err
+		}
+		return append(conflicts, c...), nil
+	case DurabilityJournal:
+		entries := make([]journalEntry, len(urls))
+		for i, u := range urls {
+			entries[i] = journalEntry{Op: journalPut, URL: u, PasswordHash: u.PasswordHash}
+		}
+		if err := s.journal.append(entries...); err != nil {
+			return nil, fmt.Errorf("write journal: %w", err)
+		}
+	}
+
+	// Add to write buffer
+	s.bufMu.Lock()
+	s.writeBuf = append(s.writeBuf, urls...)
+	for _, urlData := range urls {
+		s.pending[urlData.ShortCode] = urlData
+	}
+	shouldFlush := len(s.writeBuf) >= s.bufferSize
+	s.bufMu.Unlock()
+
+	// Trigger flush if buffer is full
+	if shouldFlush {
+		s.triggerFlush()
+	}
+
+	return conflicts, nil
 }
 
-func (s *Store) CreateShortURL(ctx context.Context, url, title string, slug string, expiry time.Duration) (string, error) {
-	var shortCode string
-	if slug != "" {
-		s.mu.RLock()
-		_, exists := s.cache[slug]
-		s.mu.RUnlock()
-		if exists {
-			return "", errors.New("slug already exists")
+// unbuffer removes a URL from the write buffer and reports whether it was there.
+func (s *SQLStore) unbuffer(shortCode string) bool {
+	s.bufMu.Lock()
+	defer s.bufMu.Unlock()
+
+	for i := range s.writeBuf {
+		if s.writeBuf[i].ShortCode == shortCode {
+			s.writeBuf = append(s.writeBuf[:i], s.writeBuf[i+1:]...)
+			delete(s.pending, shortCode)
+			return true
 		}
-		shortCode = slug
-	} else {
-		shortCode = generateRandomString(s.shortURLLen)
-		for {
-			s.mu.RLock()
-			_, exists := s.cache[shortCode]
-			s.mu.RUnlock()
-			if !exists {
-				break
+	}
+	return false
+}
+
+// forget keeps a URL that's waiting to be flushed from being inserted, because
+// it's been deleted or written some other way, and reports whether it was
+// waiting.
+// s.flushMu must be held so that it isn't being flushed right now.
+func (s *SQLStore) forget(shortCode string) bool {
+	if s.unbuffer(shortCode) {
+		return true
+	}
+
+	s.bufMu.Lock()
+	defer s.bufMu.Unlock()
+	if _, ok := s.pending[shortCode]; !ok {
+		return false
+	}
+	// It's in a batch that's queued for flushing
+	delete(s.pending, shortCode)
+	s.skip[shortCode] = struct{}{}
+	return true
+}
+
+// dropUnflushed is called before deleting a URL, which may not have been
+// flushed yet. Once a flush that may be inserting it right now is done, it's
+// either in the database or kept from being inserted later. It reports whether
+// the URL was still buffered.
+func (s *SQLStore) dropUnflushed(shortCode string) (bool, error) {
+	buffered := false
+	if _, pending := s.pendingURL(shortCode); pending {
+		s.flushMu.Lock()
+		buffered = s.forget(shortCode)
+		s.flushMu.Unlock()
+	}
+	if s.journal != nil {
+		if err := s.journal.append(journalEntry{Op: journalDel, URL: models.URLData{ShortCode: shortCode}}); err != nil {
+			return buffered, fmt.Errorf("write journal: %w", err)
+		}
+		if buffered {
+			if err := s.journal.flushed(shortCode); err != nil {
+				s.logger.Error("failed to compact journal", "error", err)
 			}
-			shortCode = generateRandomString(6)
 		}
 	}
+	return buffered, nil
+}
 
-	createdAt := time.Now()
-	urlData := models.URLData{
-		URL:       url,
-		Title:     title,
-		ShortCode: shortCode,
-		CreatedAt: createdAt,
+func (s *SQLStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// CreateShortURL saves a new URL. Its ShortCode is used as a custom slug if
+// set, otherwise a random one is generated.
+func (s *SQLStore) CreateShortURL(ctx context.Context, urlData models.URLData, expiry time.Duration) (string, error) {
+	// Only the reservation is done under the lock, which every redirect takes,
+	// not the database or journal write
+	s.mu.Lock()
+	shortCode, err := s.reserve(ctx, urlData.ShortCode)
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
 	}
 
+	createdAt := time.Now()
+	urlData.ShortCode = shortCode
+	urlData.CreatedAt = createdAt
+	urlData.ExpiresAt = nil
+	setClickLimit(&urlData, urlData.MaxClicks)
+
 	if expiry > 0 {
 		t := createdAt.Add(expiry)
 		urlData.ExpiresAt = &t
 	}
 
-	// Update cache immediately
+	conflicts, err := s.persist([]models.URLData{urlData})
+
 	s.mu.Lock()
-	s.cache[shortCode] = urlData
-	metrics.URLsStoredGauge.Set(float64(len(s.cache)))
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+	delete(s.reserved, shortCode)
+	if err != nil {
+		return "", err
+	}
+	if len(conflicts) > 0 {
+		// Taken by another instance sharing the database
+		return "", ErrSlugExists
+	}
 
-	// Add to write buffer
-	s.bufMu.Lock()
-	s.writeBuf = append(s.writeBuf, urlData)
-	shouldFlush := len(s.writeBuf) >= s.bufferSize
-	s.bufMu.Unlock()
+	// Update cache immediately
+	s.cache.set(urlData)
+	metrics.URLsStoredGauge.Inc()
 
-	// Trigger flush if buffer is full
-	if shouldFlush {
-		s.triggerFlush()
+	return shortCode, nil
+}
+
+// reserve claims the short code of a URL that's about to be persisted: slug if
+// it's set and free, a new random one otherwise. It's kept from concurrent
+// creates until it's deleted from s.reserved again. s.mu must be held.
+func (s *SQLStore) reserve(ctx context.Context, slug string) (string, error) {
+	shortCode := slug
+	if slug != "" {
+		exists, err := s.exists(ctx, slug)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "", ErrSlugExists
+		}
+	} else {
+		code, err := s.newShortCode(ctx)
+		if err != nil {
+			return "", err
+		}
+		shortCode = code
 	}
 
+	s.reserved[shortCode] = struct{}{}
 	return shortCode, nil
 }
 
-func (s *Store) GetRedirectData(ctx context.Context, shortCode string) (models.URLData, error) {
-	s.mu.RLock()
-	urlData, exists := s.cache[shortCode]
-	s.mu.RUnlock()
-
+func (s *SQLStore) GetRedirectData(ctx context.Context, shortCode string) (models.URLData, error) {
+	urlData, exists, err := s.lookup(ctx, shortCode)
+	if err != nil {
+		return models.URLData{}, err
+	}
 	if !exists {
 		return models.URLData{}, ErrNotExist
 	}
 
-	if urlData.ExpiresAt != nil && time.Now().After(*urlData.ExpiresAt) {
-		// URL has expired, remove it
+	if urlData.Expired() {
+		// URLs with a fallback are kept so that it keeps being served
+		if urlData.ExpiredRedirectURL != "" {
+			return urlData, ErrExpired
+		}
+
+		// URL has expired or run out of clicks, remove it
 		s.mu.Lock()
-		delete(s.cache, shortCode)
-		metrics.URLsStoredGauge.Set(float64(len(s.cache)))
+		s.cache.remove(shortCode)
+		s.cacheGen++
 		s.mu.Unlock()
-		_, err := s.db.ExecContext(ctx, `DELETE FROM urls WHERE short_code = ?`, shortCode)
+		metrics.URLsStoredGauge.Dec()
+		if _, err := s.dropUnflushed(shortCode); err != nil {
+			s.logger.Error("failed to delete expired url", "error", err)
+		}
+		_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM urls WHERE short_code = ?`), shortCode)
 		if err != nil {
 			s.logger.Error("failed to delete expired url", "error", err)
 		}
+		if err := s.deleteClicks(ctx, shortCode); err != nil {
+			s.logger.Error("failed to delete clicks of expired url", "error", err)
+		}
 		return models.URLData{}, ErrNotExist
 	}
+	if urlData.Scheduled() {
+		return models.URLData{}, ErrNotActive
+	}
 
 	return urlData, nil
 }
 
-func (s *Store) DeleteURL(ctx context.Context, shortCode string) error {
+// GetURL returns a URL without following it: unlike GetRedirectData, links
+// that aren't active yet are returned too and expired ones are left alone.
+func (s *SQLStore) GetURL(ctx context.Context, shortCode string) (models.URLData, error) {
+	urlData, exists, err := s.lookup(ctx, shortCode)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	if !exists || (urlData.Expired() && urlData.ExpiredRedirectURL == "") {
+		return models.URLData{}, ErrNotExist
+	}
+	return urlData, nil
+}
+
+func (s *SQLStore) DeleteURL(ctx context.Context, shortCode string) error {
+	buffered, err := s.dropUnflushed(shortCode)
+	if err != nil {
+		return err
+	}
+
 	// Delete from database
-	result, err := s.db.ExecContext(ctx, `DELETE FROM urls WHERE short_code = ?`, shortCode)
+	result, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM urls WHERE short_code = ?`), shortCode)
 	if err != nil {
 		return err
 	}
@@ -343,89 +778,243 @@ func (s *Store) DeleteURL(ctx context.Context, shortCode string) error {
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
+	if rowsAffected == 0 && !buffered {
 		return ErrNotExist
 	}
 
+	if err := s.deleteClicks(ctx, shortCode); err != nil {
+		return err
+	}
+
 	// Delete from cache
 	s.mu.Lock()
-	delete(s.cache, shortCode)
-	metrics.URLsStoredGauge.Set(float64(len(s.cache)))
+	s.cache.remove(shortCode)
+	s.cacheGen++
 	s.mu.Unlock()
+	metrics.URLsStoredGauge.Dec()
 
 	return nil
 }
 
-func (s *Store) GetURLs(ctx context.Context, page, perPage int64) ([]models.URLData, int64, error) {
-	offset := (page - 1) * perPage
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT short_code, url, title, created_at, expires_at
-		FROM urls
-		WHERE expires_at IS NULL OR expires_at > datetime('now')
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?`,
-		perPage, offset)
+// UpdateURL applies a partial update to an existing URL and returns the updated record.
+// The row is upserted so that the change lands even if the original insert is still
+// sitting in the write buffer; any buffered copy is patched too so that the pending
+// insert carries the same values.
+func (s *SQLStore) UpdateURL(ctx context.Context, shortCode string, upd models.URLUpdate) (models.URLData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urlData, exists := s.cache.get(shortCode)
+	if !exists && !s.cache.complete() {
+		var err error
+		if urlData, exists, err = s.fetch(ctx, shortCode); err != nil {
+			return models.URLData{}, err
+		}
+	}
+	// Expired URLs are only still around if they have a fallback
+	if !exists || (urlData.Expired() && urlData.ExpiredRedirectURL == "") {
+		return models.URLData{}, ErrNotExist
+	}
+
+	if upd.URL != nil {
+		urlData.URL = *upd.URL
+	}
+	if upd.Title != nil {
+		urlData.Title = *upd.Title
+	}
+	if upd.Description != nil {
+		urlData.Description = *upd.Description
+	}
+	if upd.Favicon != nil {
+		urlData.Favicon = *upd.Favicon
+	}
+	if upd.ClearExpiry {
+		urlData.ExpiresAt = nil
+	} else if upd.ExpiresAt != nil {
+		t := *upd.ExpiresAt
+		urlData.ExpiresAt = &t
+	}
+	if upd.ClearActiveFrom {
+		urlData.ActiveFrom = nil
+	} else if upd.ActiveFrom != nil {
+		t := *upd.ActiveFrom
+		urlData.ActiveFrom = &t
+	}
+	if upd.RedirectType != nil {
+		urlData.RedirectType = *upd.RedirectType
+	}
+	if upd.PasswordHash != nil {
+		urlData.PasswordHash = *upd.PasswordHash
+	}
+	if upd.ExpiredRedirectURL != nil {
+		urlData.ExpiredRedirectURL = *upd.ExpiredRedirectURL
+	}
+	if upd.Variants != nil {
+		urlData.Variants = *upd.Variants
+	}
+	if upd.StickyVariants != nil {
+		urlData.StickyVariants = *upd.StickyVariants
+	}
+	if upd.Rules != nil {
+		urlData.Rules = *upd.Rules
+	}
+	if upd.ForwardQuery != nil {
+		urlData.ForwardQuery = *upd.ForwardQuery
+	}
+	if upd.ForwardPath != nil {
+		urlData.ForwardPath = *upd.ForwardPath
+	}
+	if upd.Interstitial != nil {
+		urlData.Interstitial = *upd.Interstitial
+	}
+	if upd.OG != nil {
+		urlData.OG = nil
+		if !upd.OG.IsZero() {
+			og := *upd.OG
+			urlData.OG = &og
+		}
+	}
+
+	// The remaining clicks are only overwritten when the limit is reset, so
+	// that clicks consumed in the meantime aren't given back.
+	set := `url = excluded.url,
+			title = excluded.title,
+			description = excluded.description,
+			favicon = excluded.favicon,
+			expires_at = excluded.expires_at,
+			active_from = excluded.active_from,
+			redirect_type = excluded.redirect_type,
+			password_hash = excluded.password_hash,
+			expired_redirect_url = excluded.expired_redirect_url,
+			variants = excluded.variants,
+			sticky_variants = excluded.sticky_variants,
+			rules = excluded.rules,
+			forward_query = excluded.forward_query,
+			forward_path = excluded.forward_path,
+			interstitial = excluded.interstitial,
+			og_title = excluded.og_title,
+			og_description = excluded.og_description,
+			og_image = excluded.og_image`
+	if upd.MaxClicks != nil {
+		setClickLimit(&urlData, *upd.MaxClicks)
+		set += `,
+			max_clicks = excluded.max_clicks,
+			remaining_clicks = excluded.remaining_clicks`
+	}
+
+	// A URL that's still waiting to be flushed is written here instead, so
+	// wait for a flush that may be inserting it right now
+	if _, pending := s.pendingURL(shortCode); pending {
+		s.flushMu.Lock()
+		defer s.flushMu.Unlock()
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO urls (`+urlColumnList("")+`) VALUES `+urlPlaceholders()+`
+		ON CONFLICT(short_code) DO UPDATE SET `+set),
+		urlArgs(urlData)...)
 	if err != nil {
-		return nil, 0, err
+		return models.URLData{}, err
 	}
-	defer rows.Close()
 
-	var urls []models.URLData
-	for rows.Next() {
-		var urlData models.URLData
-		var expiresAt sql.NullTime
-		err := rows.Scan(&urlData.ShortCode, &urlData.URL, &urlData.Title, &urlData.CreatedAt, &expiresAt)
-		if err != nil {
-			return nil, 0, err
+	if s.forget(shortCode) && s.journal != nil {
+		if err := s.journal.flushed(shortCode); err != nil {
+			s.logger.Error("failed to compact journal", "error", err)
 		}
-		if expiresAt.Valid {
-			urlData.ExpiresAt = &expiresAt.Time
+	}
+
+	s.cache.set(urlData)
+	s.cacheGen++
+
+	return urlData, nil
+}
+
+// FillMetadata stores the title, description and favicon fetched from the
+// target of a URL. Only the fields that are still empty are set, in a single
+// conditional update, so that values set by the user are never overwritten,
+// even if they're set while the target is being fetched.
+func (s *SQLStore) FillMetadata(ctx context.Context, shortCode, title, description, favicon string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A URL that's still waiting to be flushed is written here first, so wait
+	// for a flush that may be inserting it right now
+	if urlData, pending := s.pendingURL(shortCode); pending {
+		s.flushMu.Lock()
+		defer s.flushMu.Unlock()
+
+		if _, err := s.db.ExecContext(ctx,
+			s.rebind(`INSERT INTO urls (`+urlColumnList("")+`) VALUES `+urlPlaceholders()+`
+			ON CONFLICT(short_code) DO NOTHING`),
+			urlArgs(urlData)...); err != nil {
+			return err
+		}
+		if s.forget(shortCode) && s.journal != nil {
+			if err := s.journal.flushed(shortCode); err != nil {
+				s.logger.Error("failed to compact journal", "error", err)
+			}
 		}
-		urls = append(urls, urlData)
 	}
-	// Get total count
-	var total int64
-	err = s.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM urls WHERE expires_at IS NULL OR expires_at > datetime('now')`).Scan(&total)
+
+	var (
+		newTitle                sql.NullString
+		newDescription, newIcon string
+	)
+	err := s.db.QueryRowContext(ctx,
+		s.rebind(`UPDATE urls SET
+			title = CASE WHEN COALESCE(title, '') = '' THEN ? ELSE title END,
+			description = CASE WHEN description = '' THEN ? ELSE description END,
+			favicon = CASE WHEN favicon = '' THEN ? ELSE favicon END
+		WHERE short_code = ?
+		RETURNING title, description, favicon`),
+		title, description, favicon, shortCode).Scan(&newTitle, &newDescription, &newIcon)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted in the meantime
+		return nil
+	}
 	if err != nil {
-		return nil, 0, err
+		return err
+	}
+
+	if urlData, ok := s.cache.get(shortCode); ok {
+		urlData.Title = newTitle.String
+		urlData.Description = newDescription
+		urlData.Favicon = newIcon
+		s.cache.set(urlData)
 	}
+	s.cacheGen++
 
-	return urls, total, rows.Err()
+	return nil
 }
 
-func (s *Store) CreateShortURLs(ctx context.Context, urls []models.URLData) []map[string]string {
-	var results []map[string]string
+func (s *SQLStore) CreateShortURLs(ctx context.Context, urls []models.URLData) []map[string]string {
+	var (
+		results  []map[string]string
+		accepted []models.URLData
+	)
 
+	// Reserve the short codes under the lock, so that later entries in the
+	// batch and concurrent creates can't claim them, and persist outside of it
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	for _, urlData := range urls {
-		var shortCode string
-		if urlData.ShortCode != "" {
-			_, exists := s.cache[urlData.ShortCode]
-			if exists {
-				results = append(results, map[string]string{
-					"url":   urlData.URL,
-					"error": "slug already exists",
-				})
-				continue
-			}
-			shortCode = urlData.ShortCode
-		} else {
-			shortCode = generateRandomString(s.shortURLLen)
-			for {
-				_, exists := s.cache[shortCode]
-				if !exists {
-					break
-				}
-				shortCode = generateRandomString(s.shortURLLen)
+		shortCode, err := s.reserve(ctx, urlData.ShortCode)
+		if err != nil {
+			msg := ErrSlugExists.Error()
+			if err != ErrSlugExists {
+				s.logger.Error("failed to reserve short code", "error", err, "slug", urlData.ShortCode)
+				msg = "failed to save url"
 			}
+			results = append(results, map[string]string{
+				"url":   urlData.URL,
+				"error": msg,
+			})
+			continue
 		}
 
 		createdAt := time.Now()
 		urlData.ShortCode = shortCode
 		urlData.CreatedAt = createdAt
+		setClickLimit(&urlData, urlData.MaxClicks)
 
 		if urlData.ExpiresAt != nil && urlData.ExpiresAt.After(createdAt) {
 			expiry := time.Until(*urlData.ExpiresAt)
@@ -433,22 +1022,82 @@ func (s *Store) CreateShortURLs(ctx context.Context, urls []models.URLData) []ma
 			urlData.ExpiresAt = &t
 		}
 
-		s.cache[shortCode] = urlData
-		s.writeBuf = append(s.writeBuf, urlData)
-
+		accepted = append(accepted, urlData)
 		results = append(results, map[string]string{
 			"url":      urlData.URL,
 			"shortUrl": shortCode,
 		})
 	}
+	s.mu.Unlock()
 
-	if len(s.writeBuf) >= s.bufferSize {
-		s.triggerFlush()
+	if len(accepted) == 0 {
+		return results
 	}
 
+	conflicts, err := s.persist(accepted)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, urlData := range accepted {
+		delete(s.reserved, urlData.ShortCode)
+	}
+
+	if err != nil {
+		s.logger.Error("failed to persist urls", "error", err, "count", len(accepted))
+		for _, r := range results {
+			if r["shortUrl"] != "" {
+				delete(r, "shortUrl")
+				r["error"] = "failed to save url"
+			}
+		}
+		return results
+	}
+
+	// Taken by another instance sharing the database
+	taken := make(map[string]bool, len(conflicts))
+	for _, shortCode := range conflicts {
+		taken[shortCode] = true
+	}
+	for _, r := range results {
+		if taken[r["shortUrl"]] {
+			delete(r, "shortUrl")
+			r["error"] = ErrSlugExists.Error()
+		}
+	}
+	for _, urlData := range accepted {
+		if !taken[urlData.ShortCode] {
+			s.cache.set(urlData)
+		}
+	}
+	metrics.URLsStoredGauge.Add(float64(len(accepted) - len(conflicts)))
+
 	return results
 }
 
+// setClickLimit sets the click limit of a URL and resets its remaining clicks.
+func setClickLimit(urlData *models.URLData, maxClicks int64) {
+	urlData.MaxClicks = maxClicks
+	urlData.RemainingClicks = nil
+	if maxClicks > 0 {
+		remaining := maxClicks
+		urlData.RemainingClicks = &remaining
+	}
+}
+
+// newShortCode returns a random short code that isn't taken yet. s.mu must be held.
+func (s *SQLStore) newShortCode(ctx context.Context) (string, error) {
+	for {
+		shortCode := generateRandomString(s.shortURLLen)
+		exists, err := s.exists(ctx, shortCode)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return shortCode, nil
+		}
+	}
+}
+
 // generateRandomString creates a random string of specified length
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
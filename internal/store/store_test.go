@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/lil/models"
+)
+
+// newTestStore opens a store on a temporary SQLite database. Buffered URLs are
+// only flushed when the buffer is full or the store is closed.
+func newTestStore(t *testing.T, durability string) (*SQLStore, Conf) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := Conf{
+		Driver:        "sqlite",
+		DBPath:        filepath.Join(dir, "lil.db"),
+		BufferSize:    100,
+		FlushInterval: time.Hour,
+		Durability:    durability,
+		JournalPath:   filepath.Join(dir, "lil.journal"),
+	}
+	s, err := New(cfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*SQLStore), cfg
+}
+
+func TestCreateShortURLSameSlug(t *testing.T) {
+	for _, durability := range []string{DurabilityBuffered, DurabilitySync, DurabilityJournal} {
+		t.Run(durability, func(t *testing.T) {
+			s, _ := newTestStore(t, durability)
+			defer s.Close()
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				created  int
+				conflict int
+			)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := s.CreateShortURL(context.Background(), models.URLData{URL: "https://example.com", ShortCode: "same"}, 0)
+					mu.Lock()
+					defer mu.Unlock()
+					switch err {
+					case nil:
+						created++
+					case ErrSlugExists:
+						conflict++
+					default:
+						t.Errorf("CreateShortURL() error = %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if created != 1 || conflict != 7 {
+				t.Errorf("created %d and rejected %d, want 1 and 7", created, conflict)
+			}
+			if len(s.reserved) != 0 {
+				t.Errorf("reservations left behind: %v", s.reserved)
+			}
+		})
+	}
+}
+
+func TestExpiredBufferedURLIsNotFlushed(t *testing.T) {
+	s, cfg := newTestStore(t, DurabilityBuffered)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	res := s.CreateShortURLs(ctx, []models.URLData{{URL: "https://example.com", ShortCode: "expired", ExpiresAt: &past}})
+	if res[0]["error"] != "" {
+		t.Fatal(res[0]["error"])
+	}
+	if _, err := s.GetRedirectData(ctx, "expired"); err != ErrNotExist {
+		t.Fatalf("GetRedirectData() error = %v, want ErrNotExist", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := New(cfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	var n int
+	if err := s2.(*SQLStore).db.QueryRow(`SELECT COUNT(*) FROM urls WHERE short_code = 'expired'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("deleted expired url was written by the flush on close")
+	}
+}
+
+func TestCloseReportsUnflushedURLs(t *testing.T) {
+	s, _ := newTestStore(t, DurabilityBuffered)
+
+	if _, err := s.CreateShortURL(context.Background(), models.URLData{URL: "https://example.com", ShortCode: "lost"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Make every flush fail
+	if _, err := s.db.Exec(`DROP TABLE urls`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Close()
+	if err == nil || !strings.Contains(err.Error(), "lost") {
+		t.Errorf("Close() error = %v, want one naming the unflushed url", err)
+	}
+}
+
+func TestFillMetadata(t *testing.T) {
+	s, cfg := newTestStore(t, DurabilityBuffered)
+	ctx := context.Background()
+
+	// Still buffered, with a title set by the user
+	if _, err := s.CreateShortURL(ctx, models.URLData{URL: "https://example.com", ShortCode: "meta", Title: "Mine"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FillMetadata(ctx, "meta", "Fetched", "About", "https://example.com/favicon.ico"); err != nil {
+		t.Fatal(err)
+	}
+	// A later fetch doesn't overwrite what's been filled in
+	if err := s.FillMetadata(ctx, "meta", "Other", "Other", "Other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FillMetadata(ctx, "missing", "Other", "Other", "Other"); err != nil {
+		t.Errorf("FillMetadata() of a missing url error = %v", err)
+	}
+
+	want := models.URLData{Title: "Mine", Description: "About", Favicon: "https://example.com/favicon.ico"}
+	check := func(s Store) {
+		t.Helper()
+		got, err := s.GetURL(ctx, "meta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != want.Title || got.Description != want.Description || got.Favicon != want.Favicon {
+			t.Errorf("got title %q, description %q, favicon %q, want %q, %q, %q",
+				got.Title, got.Description, got.Favicon, want.Title, want.Description, want.Favicon)
+		}
+	}
+	check(s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := New(cfg, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	check(s2)
+}
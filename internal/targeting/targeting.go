@@ -0,0 +1,301 @@
+// Package targeting picks the target of a link from rules matched against the
+// platform, device, language and country of the visitor.
+package targeting
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mr-karan/lil/models"
+)
+
+// Operating systems a rule can match.
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+	OSOther    = "other"
+)
+
+// Device types a rule can match.
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// MaxRules caps the number of rules of a link.
+const MaxRules = 20
+
+var (
+	validOS      = []string{OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux, OSChromeOS, OSOther}
+	validDevices = []string{DeviceMobile, DeviceTablet, DeviceDesktop, DeviceBot}
+)
+
+// Client describes the visitor of a link.
+type Client struct {
+	OS     string
+	Device string
+	// Language is the most preferred language of the visitor in lower case,
+	// e.g. "de-at", or "" if it sent none.
+	Language string
+	// Country is the ISO 3166-1 alpha-2 code of the country of the visitor, or
+	// "" if it's unknown. It's filled in by the caller.
+	Country string
+}
+
+// FromRequest describes the visitor from the User-Agent and Accept-Language
+// headers of the request.
+func FromRequest(r *http.Request) Client {
+	os, device := parseUserAgent(r.UserAgent())
+	return Client{
+		OS:       os,
+		Device:   device,
+		Language: preferredLanguage(r.Header.Get("Accept-Language")),
+	}
+}
+
+// Match returns the first rule that matches the client.
+func Match(rules []models.Rule, c Client) (models.Rule, bool) {
+	for _, rule := range rules {
+		if c.matches(rule) {
+			return rule, true
+		}
+	}
+	return models.Rule{}, false
+}
+
+// matches reports whether the client meets every condition of the rule. A
+// condition with several values is met by any of them.
+func (c Client) matches(rule models.Rule) bool {
+	if len(rule.OS) > 0 && !slices.Contains(rule.OS, c.OS) {
+		return false
+	}
+	if len(rule.Devices) > 0 && !slices.Contains(rule.Devices, c.Device) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !slices.ContainsFunc(rule.Languages, c.speaks) {
+		return false
+	}
+	if len(rule.Countries) > 0 && !slices.ContainsFunc(rule.Countries, c.inCountry) {
+		return false
+	}
+	return true
+}
+
+// speaks reports whether the client prefers a language. A language without a
+// region, like "de", matches all of its regional variants.
+func (c Client) speaks(lang string) bool {
+	lang = strings.ToLower(lang)
+	return c.Language == lang || strings.HasPrefix(c.Language, lang+"-")
+}
+
+// inCountry reports whether the client is in a country.
+func (c Client) inCountry(country string) bool {
+	return c.Country != "" && strings.EqualFold(c.Country, country)
+}
+
+// UsesCountries reports whether any of the rules has a countries condition.
+func UsesCountries(rules []models.Rule) bool {
+	return slices.ContainsFunc(rules, func(rule models.Rule) bool { return len(rule.Countries) > 0 })
+}
+
+// Validate checks the rules of a link. Every rule needs a target URL and at
+// least one condition.
+func Validate(rules []models.Rule) error {
+	if len(rules) > MaxRules {
+		return fmt.Errorf("at most %d rules are allowed", MaxRules)
+	}
+
+	for i, rule := range rules {
+		if rule.URL == "" {
+			return fmt.Errorf("rule %d has no url", i+1)
+		}
+		if len(rule.OS) == 0 && len(rule.Devices) == 0 && len(rule.Languages) == 0 && len(rule.Countries) == 0 {
+			return fmt.Errorf("rule %d has no conditions", i+1)
+		}
+		for _, os := range rule.OS {
+			if !slices.Contains(validOS, os) {
+				return fmt.Errorf("rule %d has an unknown os %q, must be one of %s", i+1, os, strings.Join(validOS, ", "))
+			}
+		}
+		for _, device := range rule.Devices {
+			if !slices.Contains(validDevices, device) {
+				return fmt.Errorf("rule %d has an unknown device %q, must be one of %s", i+1, device, strings.Join(validDevices, ", "))
+			}
+		}
+		for _, lang := range rule.Languages {
+			if lang == "" || strings.ContainsAny(lang, " ,;*") {
+				return fmt.Errorf("rule %d has an invalid language %q", i+1, lang)
+			}
+		}
+		for _, country := range rule.Countries {
+			if !validCountry(country) {
+				return fmt.Errorf("rule %d has an invalid country %q, use two letter ISO codes like DE", i+1, country)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validCountry reports whether s looks like an ISO 3166-1 alpha-2 code.
+func validCountry(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// socialCrawlers are the user agent tokens, in lower case, of the crawlers that
+// social networks and chat apps send to fetch the preview card of a shared link.
+var socialCrawlers = []string{
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"slackbot",
+	"linkedinbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"pinterest",
+	"redditbot",
+	"skypeuripreview",
+	"mastodon",
+	"embedly",
+	"iframely",
+	"vkshare",
+}
+
+// SocialCrawler reports whether a User-Agent header is that of a crawler
+// fetching a link to show a preview card of it.
+func SocialCrawler(userAgent string) bool {
+	lower := strings.ToLower(userAgent)
+	for _, token := range socialCrawlers {
+		if strings.Contains(lower, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bot reports whether a User-Agent header is that of a crawler, link
+// previewer or other automated client rather than a person's browser.
+func Bot(userAgent string) bool {
+	_, device := parseUserAgent(userAgent)
+	return device == DeviceBot
+}
+
+// botTokens are user agent tokens, in lower case, of crawlers that don't
+// follow the "name" + "bot/" convention.
+var botTokens = []string{
+	"crawler",
+	"spider",
+	"slurp",
+	"headlesschrome",
+	"lighthouse",
+	"+http",
+}
+
+// bot reports whether a lower case User-Agent header is that of a crawler.
+// Crawlers name themselves like "Googlebot/2.1" or "Slackbot-LinkExpanding",
+// so "bot" is only matched followed by a "/" or "-": just "bot" anywhere
+// would match phones like the "CUBOT X30".
+func bot(lower string) bool {
+	if strings.Contains(lower, "bot/") || strings.Contains(lower, "bot-") {
+		return true
+	}
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// iPadOS hints are tokens that only iOS browsers put in a user agent. iPads
+// send the user agent of a Mac by default, so these are the only way to tell
+// them apart. Safari itself sends none, so it's still taken for a Mac.
+var iPadOSHints = []string{"Mobile/", "CriOS/", "FxiOS/", "EdgiOS/", "OPT/"}
+
+// parseUserAgent guesses the operating system and device type from a
+// User-Agent header.
+func parseUserAgent(ua string) (os, device string) {
+	lower := strings.ToLower(ua)
+	if lower == "" || bot(lower) || SocialCrawler(ua) {
+		return OSOther, DeviceBot
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad"):
+		return OSiOS, DeviceTablet
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPod"):
+		return OSiOS, DeviceMobile
+	case strings.Contains(ua, "Android"):
+		// Android tablets leave "Mobile" out of the user agent
+		if strings.Contains(ua, "Mobile") {
+			return OSAndroid, DeviceMobile
+		}
+		return OSAndroid, DeviceTablet
+	case strings.Contains(ua, "Windows"):
+		os = OSWindows
+	case strings.Contains(ua, "CrOS"):
+		os = OSChromeOS
+	case strings.Contains(ua, "Macintosh"), strings.Contains(ua, "Mac OS X"):
+		if slices.ContainsFunc(iPadOSHints, func(hint string) bool { return strings.Contains(ua, hint) }) {
+			return OSiOS, DeviceTablet
+		}
+		os = OSMacOS
+	case strings.Contains(ua, "Linux"), strings.Contains(ua, "X11"):
+		os = OSLinux
+	default:
+		os = OSOther
+	}
+
+	if strings.Contains(ua, "Mobile") {
+		return os, DeviceMobile
+	}
+	return os, DeviceDesktop
+}
+
+// preferredLanguage returns the language with the highest quality in an
+// Accept-Language header, in lower case. Of languages with the same quality,
+// the first one listed wins.
+func preferredLanguage(header string) string {
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || lang == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,99 @@
+package targeting
+
+import (
+	"testing"
+
+	"github.com/mr-karan/lil/models"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name   string
+		ua     string
+		os     string
+		device string
+	}{
+		{"empty", "", OSOther, DeviceBot},
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", OSiOS, DeviceMobile},
+		{"ipad", "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1", OSiOS, DeviceTablet},
+		{"ipados webview", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", OSiOS, DeviceTablet},
+		{"ipados chrome", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.111 Safari/604.1", OSiOS, DeviceTablet},
+		{"ipados firefox", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) FxiOS/125.0 Safari/605.1.15", OSiOS, DeviceTablet},
+		{"mac safari", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", OSMacOS, DeviceDesktop},
+		{"android phone", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36", OSAndroid, DeviceMobile},
+		{"android tablet", "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", OSAndroid, DeviceTablet},
+		{"cubot phone", "Mozilla/5.0 (Linux; Android 10; CUBOT_X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", OSAndroid, DeviceMobile},
+		{"cubot phone with space", "Mozilla/5.0 (Linux; Android 11; CUBOT NOTE 20 Build/RP1A) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", OSAndroid, DeviceMobile},
+		{"windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", OSWindows, DeviceDesktop},
+		{"chromeos", "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", OSChromeOS, DeviceDesktop},
+		{"linux", "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", OSLinux, DeviceDesktop},
+		{"googlebot", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", OSOther, DeviceBot},
+		{"googlebot smartphone", "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", OSOther, DeviceBot},
+		{"bingbot", "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", OSOther, DeviceBot},
+		{"slackbot", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", OSOther, DeviceBot},
+		{"whatsapp", "WhatsApp/2.23.20.0", OSOther, DeviceBot},
+		{"yahoo", "Mozilla/5.0 (compatible; Yahoo! Slurp; http://help.yahoo.com/help/us/ysearch/slurp)", OSOther, DeviceBot},
+		{"unknown", "curl/8.5.0", OSOther, DeviceDesktop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os, device := parseUserAgent(tt.ua)
+			if os != tt.os || device != tt.device {
+				t.Errorf("parseUserAgent(%q) = %s, %s, want %s, %s", tt.ua, os, device, tt.os, tt.device)
+			}
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-AT", "de-at"},
+		{"en-US,en;q=0.9,de;q=0.8", "en-us"},
+		{"de;q=0.5, fr;q=0.9", "fr"},
+		{"fr;q=0.9, de;q=0.9", "fr"},
+		{"*, nl;q=0.5", "nl"},
+		{"es;q=bad, it;q=0.1", "it"},
+		{"en;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		if got := preferredLanguage(tt.header); got != tt.want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rules := []models.Rule{
+		{OS: []string{OSiOS}, URL: "https://apps.apple.com"},
+		{OS: []string{OSAndroid}, URL: "https://play.google.com"},
+		{Languages: []string{"de"}, Countries: []string{"at"}, URL: "https://example.at"},
+	}
+
+	tests := []struct {
+		name   string
+		client Client
+		want   string
+	}{
+		{"ios", Client{OS: OSiOS, Device: DeviceTablet}, "https://apps.apple.com"},
+		{"android", Client{OS: OSAndroid, Device: DeviceMobile}, "https://play.google.com"},
+		{"regional language and country", Client{OS: OSWindows, Language: "de-at", Country: "AT"}, "https://example.at"},
+		{"language without country", Client{OS: OSWindows, Language: "de"}, ""},
+		{"no match", Client{OS: OSMacOS, Language: "en"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := Match(rules, tt.client)
+			if got := rule.URL; got != tt.want || ok != (tt.want != "") {
+				t.Errorf("Match() = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
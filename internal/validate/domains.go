@@ -0,0 +1,59 @@
+package validate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// domainList is a set of domains, each of which also covers its subdomains.
+type domainList map[string]bool
+
+// loadDomainList reads a file with one domain per line. Blank lines and
+// anything after a # are ignored, and a leading "*." is optional.
+func loadDomainList(path string) (domainList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open domain list: %w", err)
+	}
+	defer f.Close()
+
+	list := make(domainList)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimPrefix(strings.TrimSpace(line), "*.")
+		line = strings.Trim(line, ".")
+		if line == "" {
+			continue
+		}
+
+		domain, err := idna.Lookup.ToASCII(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid domain %q", path, n, line)
+		}
+		list[domain] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read domain list: %w", err)
+	}
+
+	return list, nil
+}
+
+// contains reports whether a domain or one of its parents is on the list.
+func (l domainList) contains(domain string) bool {
+	for {
+		if l[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+		domain = parent
+	}
+}
@@ -0,0 +1,237 @@
+// Package validate checks and normalizes the target URLs of links: only
+// allowed schemes, no credentials, no links back to the shortener itself, and
+// optionally only domains on an allow list and none on a deny list.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultMaxLength caps the length of a target URL if no other limit is set.
+const DefaultMaxLength = 2048
+
+// Config of a Validator.
+type Config struct {
+	// Schemes are the allowed URL schemes, http and https if empty.
+	Schemes []string
+	// MaxLength caps the length of a URL, DefaultMaxLength if 0.
+	MaxLength int
+	// PublicURL is the base URL of the short links. Targets on its host would
+	// redirect back to the shortener, possibly in a loop.
+	PublicURL string
+	// AllowList and DenyList are paths of files listing one domain per line.
+	// A domain also covers its subdomains. With an allow list, only the
+	// domains on it are accepted, so an allow list without any domains
+	// accepts none. Either can be empty to not use it.
+	AllowList string
+	DenyList  string
+}
+
+// Validator checks target URLs. It's safe for concurrent use.
+type Validator struct {
+	schemes   map[string]bool
+	maxLength int
+	// publicHost is the normalized host name of the short links, without
+	// the port.
+	publicHost string
+	logger     *slog.Logger
+
+	// hasAllowList is set if an allow list is configured.
+	hasAllowList bool
+	mu           sync.RWMutex
+	allow        domainList
+	deny         domainList
+
+	watcher *watcher
+}
+
+// New creates a Validator and loads its domain lists. They're reloaded when
+// the files change until Close is called.
+func New(cfg Config, logger *slog.Logger) (*Validator, error) {
+	v := &Validator{
+		schemes:   make(map[string]bool),
+		maxLength: cfg.MaxLength,
+		logger:    logger,
+	}
+	if v.maxLength <= 0 {
+		v.maxLength = DefaultMaxLength
+	}
+
+	schemes := cfg.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	for _, s := range schemes {
+		v.schemes[strings.ToLower(s)] = true
+	}
+
+	if cfg.PublicURL != "" {
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid public url: %s", cfg.PublicURL)
+		}
+		host, err := normalizeHost(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public url: %w", err)
+		}
+		v.publicHost = (&url.URL{Host: host}).Hostname()
+	}
+
+	lists := map[string]*domainList{}
+	if cfg.AllowList != "" {
+		lists[cfg.AllowList] = &v.allow
+		v.hasAllowList = true
+	}
+	if cfg.DenyList != "" {
+		lists[cfg.DenyList] = &v.deny
+	}
+	for path, list := range lists {
+		l, err := loadDomainList(path)
+		if err != nil {
+			return nil, err
+		}
+		*list = l
+	}
+
+	if len(lists) > 0 {
+		w, err := watch(lists, v.reload, logger)
+		if err != nil {
+			return nil, err
+		}
+		v.watcher = w
+	}
+
+	return v, nil
+}
+
+// Close stops watching the domain lists.
+func (v *Validator) Close() error {
+	if v.watcher == nil {
+		return nil
+	}
+	return v.watcher.close()
+}
+
+// Check validates a target URL and returns its normalized form: the scheme and
+// host in lower case, international domain names in their ASCII form and
+// default ports removed. The errors are meant to be shown to the user after
+// the name of the field, e.g. "url: scheme javascript is not allowed".
+func (v *Validator) Check(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("empty URL")
+	}
+	if len(raw) > v.maxLength {
+		return "", fmt.Errorf("longer than %d characters", v.maxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("not a valid URL")
+	}
+	if u.Scheme == "" {
+		return "", errors.New("not an absolute URL, e.g. https://example.com")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !v.schemes[u.Scheme] {
+		return "", fmt.Errorf("scheme %s is not allowed", u.Scheme)
+	}
+	// URLs without a host, like mailto:, can't be checked any further
+	if u.Opaque != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return u.String(), nil
+	}
+	if u.Host == "" {
+		return "", errors.New("no host")
+	}
+	// Credentials are a classic way to disguise the real host of a link,
+	// as in https://trusted.example@evil.example
+	if u.User != nil {
+		return "", errors.New("credentials are not allowed")
+	}
+
+	host, err := normalizeHost(u)
+	if err != nil {
+		return "", err
+	}
+	u.Host = host
+
+	// Any port or scheme of the shortener's host leads back to it
+	domain := u.Hostname()
+	if v.publicHost != "" && domain == v.publicHost {
+		return "", errors.New("points back to this shortener")
+	}
+
+	v.mu.RLock()
+	allow, deny := v.allow, v.deny
+	v.mu.RUnlock()
+	if deny.contains(domain) {
+		return "", fmt.Errorf("domain %s is not allowed", domain)
+	}
+	if v.hasAllowList && !allow.contains(domain) {
+		return "", fmt.Errorf("domain %s is not on the allow list", domain)
+	}
+
+	return u.String(), nil
+}
+
+// reload replaces a domain list with the new contents of its file. If the file
+// can't be read, or has no domains while the old list had some, the old list
+// is kept: an empty file is most likely one caught in the middle of being
+// rewritten, and an empty deny list would let everything through. Clearing a
+// list takes a restart.
+func (v *Validator) reload(path string, list *domainList) {
+	l, err := loadDomainList(path)
+	if err != nil {
+		v.logger.Error("failed to reload domain list, keeping the old one", "error", err, "path", path)
+		return
+	}
+
+	v.mu.RLock()
+	old := len(*list)
+	v.mu.RUnlock()
+	if len(l) == 0 && old > 0 {
+		v.logger.Warn("reloaded domain list is empty, keeping the old one", "path", path, "domains", old)
+		return
+	}
+
+	v.mu.Lock()
+	*list = l
+	v.mu.Unlock()
+	v.logger.Info("reloaded domain list", "path", path, "domains", len(l))
+}
+
+// normalizeHost returns the host of a URL in lower case and ASCII, without
+// the default port of its scheme.
+func normalizeHost(u *url.URL) (string, error) {
+	hostname, port := u.Hostname(), u.Port()
+	if hostname == "" {
+		return "", errors.New("no host")
+	}
+
+	if ip := net.ParseIP(hostname); ip == nil {
+		ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(hostname, "."))
+		if err != nil {
+			return "", fmt.Errorf("invalid host %s", hostname)
+		}
+		hostname = ascii
+	}
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	if strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+	if port != "" {
+		return hostname + ":" + port, nil
+	}
+	return hostname, nil
+}
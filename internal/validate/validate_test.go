@@ -0,0 +1,128 @@
+package validate
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// writeList writes a domain list to a file in dir and returns its path.
+func writeList(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	v, err := New(Config{
+		Schemes:   []string{"http", "https", "mailto"},
+		MaxLength: 64,
+		PublicURL: "https://lil.example",
+		DenyList:  writeList(t, dir, "deny.txt", "# spam\nevil.example\n*.bad.example\n"),
+	}, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "https://example.com/a?b=c#d", "https://example.com/a?b=c#d", false},
+		{"surrounding spaces", "  https://example.com  ", "https://example.com", false},
+		{"case", "HTTPS://Example.COM/Path", "https://example.com/Path", false},
+		{"default port", "https://example.com:443/", "https://example.com/", false},
+		{"other port", "http://example.com:8080/", "http://example.com:8080/", false},
+		{"idn", "https://bücher.example/", "https://xn--bcher-kva.example/", false},
+		{"trailing dot", "https://example.com./", "https://example.com/", false},
+		{"ipv6", "http://[::1]:80/", "http://[::1]/", false},
+		{"opaque", "mailto:someone@example.com", "mailto:someone@example.com", false},
+		{"empty", " ", "", true},
+		{"too long", "https://example.com/" + string(make([]byte, 64)), "", true},
+		{"relative", "example.com/path", "", true},
+		{"scheme", "javascript:alert(1)", "", true},
+		{"no host", "https:///path", "", true},
+		{"credentials", "https://trusted.example@evil.example/", "", true},
+		{"shortener", "https://lil.example/abc", "", true},
+		{"shortener other port", "http://LIL.example:8080/abc", "", true},
+		{"denied", "https://evil.example/", "", true},
+		{"denied subdomain", "https://www.evil.example/", "", true},
+		{"denied wildcard", "https://x.bad.example/", "", true},
+		{"not denied", "https://notevil.example/", "https://notevil.example/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Check(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Check(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAllowList(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		list    string
+		raw     string
+		wantErr bool
+	}{
+		{"allowed", "example.com\n", "https://example.com/", false},
+		{"allowed subdomain", "example.com\n", "https://docs.example.com/", false},
+		{"not allowed", "example.com\n", "https://example.org/", true},
+		{"empty list", "# nothing yet\n", "https://example.com/", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := New(Config{AllowList: writeList(t, dir, "allow.txt", tt.list)}, discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer v.Close()
+
+			if _, err := v.Check(tt.raw); (err != nil) != tt.wantErr {
+				t.Errorf("Check(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsListWhenEmptied(t *testing.T) {
+	dir := t.TempDir()
+	path := writeList(t, dir, "deny.txt", "evil.example\n")
+	v, err := New(Config{DenyList: path}, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	writeList(t, dir, "deny.txt", "")
+	v.reload(path, &v.deny)
+	if _, err := v.Check("https://evil.example/"); err == nil {
+		t.Error("emptied deny list was applied")
+	}
+
+	writeList(t, dir, "deny.txt", "other.example\n")
+	v.reload(path, &v.deny)
+	if _, err := v.Check("https://evil.example/"); err != nil {
+		t.Errorf("updated deny list wasn't applied: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package validate
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay is how long a file has to be left alone after a change before
+// it's reloaded. Writing a file usually takes several events, the first of
+// which may leave it truncated.
+const reloadDelay = 500 * time.Millisecond
+
+// watcher reloads domain lists when their files change.
+type watcher struct {
+	fsw  *fsnotify.Watcher
+	done chan struct{}
+}
+
+// watch calls reload for a list once its file has been written or replaced
+// and then left alone for reloadDelay. The directories are watched rather
+// than the files, as editors and config management tools often replace a
+// file instead of writing to it, which would end a watch on the file itself.
+func watch(lists map[string]*domainList, reload func(string, *domainList), logger *slog.Logger) (*watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("watch domain lists: %w", err)
+	}
+
+	byPath := make(map[string]*domainList, len(lists))
+	for path, list := range lists {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("watch domain lists: %w", err)
+		}
+		byPath[abs] = list
+		if err := fsw.Add(filepath.Dir(abs)); err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("watch %s: %w", filepath.Dir(abs), err)
+		}
+	}
+
+	w := &watcher{fsw: fsw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+
+		timers := make(map[string]*time.Timer)
+		defer func() {
+			for _, t := range timers {
+				t.Stop()
+			}
+		}()
+
+		for {
+			select {
+			case ev, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				path := filepath.Clean(ev.Name)
+				list, watched := byPath[path]
+				if !watched || !ev.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if t, ok := timers[path]; ok {
+					t.Reset(reloadDelay)
+					continue
+				}
+				timers[path] = time.AfterFunc(reloadDelay, func() { reload(path, list) })
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("domain list watcher failed", "error", err)
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// close stops the watcher.
+func (w *watcher) close() error {
+	err := w.fsw.Close()
+	<-w.done
+	return err
+}
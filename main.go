@@ -2,23 +2,45 @@ package main
 
 import (
 	"context"
+	"errors"
+	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/knadh/koanf/v2"
 	"github.com/mr-karan/lil/internal/analytics"
+	"github.com/mr-karan/lil/internal/enrich"
+	"github.com/mr-karan/lil/internal/geoip"
 	"github.com/mr-karan/lil/internal/middleware"
 	"github.com/mr-karan/lil/internal/store"
+	"github.com/mr-karan/lil/internal/utils"
+	"github.com/mr-karan/lil/internal/validate"
+	"github.com/mr-karan/lil/models"
 	"github.com/ulule/limiter/v3"
 )
 
 type App struct {
-	store     *store.Store
+	store     store.Store
 	logger    *slog.Logger
 	analytics *analytics.Manager
+	// geo resolves client IPs to countries, nil if no database is configured.
+	geo *geoip.DB
+	// enricher fetches the metadata of new links, nil if it's disabled.
+	enricher *enrich.Enricher
+	// validator checks the target URLs of new and updated links.
+	validator *validate.Validator
+	// proxies are the reverse proxies trusted to report client IPs.
+	proxies utils.TrustedProxies
+	// passwords limits the wrong passwords submitted for protected links.
+	passwords *middleware.PasswordLimiter
+
+	// notFoundPage is shown to browsers following dead links.
+	notFoundPage *template.Template
 }
 
 var (
@@ -27,28 +49,107 @@ var (
 )
 
 func main() {
+	initConfig()
+
 	app := &App{
 		logger: initLogger(ko.Bool("app.enable_debug_logs")),
 	}
 
-	// Initialize SQLite store.
-	store, err := store.New(store.Conf{
-		DBPath:              ko.MustString("db.path"),
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbConf := store.Conf{
+		Driver:              ko.String("db.driver"),
+		DBPath:              ko.String("db.path"),
+		DSN:                 ko.String("db.dsn"),
 		MaxOpenConns:        ko.MustInt("db.max_open_conns"),
 		MaxIdleConns:        ko.MustInt("db.max_idle_conns"),
 		ConnMaxLifetimeMins: ko.MustInt("db.conn_max_lifetime_mins"),
 		ShortURLLength:      ko.MustInt("app.short_url_length"),
 		BufferSize:          ko.MustInt("db.buffer_size"),
 		FlushInterval:       ko.MustDuration("db.flush_interval"),
-	}, app.logger)
+		Durability:          ko.String("db.durability"),
+		JournalPath:         ko.String("db.journal_path"),
+		CacheMode:           ko.String("db.cache_mode"),
+		CacheSize:           ko.Int("db.cache_size"),
+		NegativeCacheSize:   ko.Int("db.negative_cache_size"),
+		NegativeCacheTTL:    ko.Duration("db.negative_cache_ttl"),
+	}
+
+	if t := ko.Int("app.default_redirect_type"); t != 0 && !models.ValidRedirectType(t) {
+		app.logger.Error("Invalid app.default_redirect_type, must be one of 301, 302, 307 or 308", "value", t)
+		os.Exit(1)
+	}
+
+	switch ko.String("app.not_active_response") {
+	case "", notActiveNotFound, notActivePage:
+	case notActiveRedirect:
+		if ko.String("app.not_active_url") == "" {
+			app.logger.Error("app.not_active_url is required when app.not_active_response is redirect")
+			os.Exit(1)
+		}
+	default:
+		app.logger.Error("Invalid app.not_active_response, must be one of not_found, page or redirect", "value", ko.String("app.not_active_response"))
+		os.Exit(1)
+	}
+
+	proxies, err := utils.ParseTrustedProxies(ko.Strings("server.trusted_proxies"))
 	if err != nil {
-		app.logger.Error("Failed to initialize SQLite store", "error", err)
+		app.logger.Error("Invalid server.trusted_proxies", "error", err)
+		os.Exit(1)
+	}
+	app.proxies = proxies
+
+	app.notFoundPage = pages.Lookup("notfound.html")
+	if path := ko.String("app.not_found_template"); path != "" {
+		tmpl, err := template.ParseFiles(path)
+		if err != nil {
+			app.logger.Error("Failed to load app.not_found_template", "error", err)
+			os.Exit(1)
+		}
+		app.notFoundPage = tmpl
+	}
+
+	// Only migrate the database schema if asked to.
+	if ko.Bool("migrate-only") || ko.Bool("migrate-dry-run") {
+		app.migrate(dbConf, ko.Bool("migrate-dry-run"))
+		return
+	}
+
+	// Initialize the store for the configured database driver.
+	store, err := store.New(dbConf, app.logger)
+	if err != nil {
+		app.logger.Error("Failed to initialize store", "error", err)
 		os.Exit(1)
 	}
-	defer store.Close()
 
 	app.store = store
 
+	// Load the rules for target URLs, reloading the domain lists when they change
+	validator, err := validate.New(validate.Config{
+		Schemes:   ko.Strings("validation.allowed_schemes"),
+		MaxLength: ko.Int("validation.max_url_length"),
+		PublicURL: ko.String("app.public_url"),
+		AllowList: ko.String("validation.allow_list"),
+		DenyList:  ko.String("validation.deny_list"),
+	}, app.logger)
+	if err != nil {
+		app.logger.Error("Failed to initialize url validation", "error", err)
+		os.Exit(1)
+	}
+	app.validator = validator
+
+	// Open the geoip database used for country targeting and analytics.
+	if path := ko.String("geoip.database"); path != "" {
+		geo, err := geoip.Open(path)
+		if err != nil {
+			app.logger.Error("Failed to open geoip database", "error", err)
+			os.Exit(1)
+		}
+		app.geo = geo
+	}
+
 	// Initialize analytics manager.
 	providers := make(map[string]map[string]interface{})
 	if providersRaw := ko.Get("analytics.providers"); providersRaw != nil {
@@ -72,8 +173,23 @@ func main() {
 	}
 	app.analytics = analyticsManager
 
-	// Start analytics workers for dispatching events.
-	analyticsManager.Start(context.TODO())
+	// Start analytics workers for dispatching events. They're stopped separately
+	// on shutdown so that queued events can be drained.
+	if analyticsManager != nil {
+		analyticsManager.Start(context.Background())
+	}
+
+	// Start the workers filling in the title, description and favicon of new links
+	if ko.Bool("enrich.enabled") {
+		app.enricher = enrich.New(enrich.Config{
+			NumWorkers: ko.Int("enrich.num_workers"),
+			QueueSize:  ko.Int("enrich.queue_size"),
+			Timeout:    ko.Duration("enrich.timeout"),
+			MaxBytes:   ko.Int64("enrich.max_bytes"),
+			UserAgent:  ko.String("enrich.user_agent"),
+		}, app.storeMetadata, app.logger)
+		app.enricher.Start(context.Background())
+	}
 
 	// Defining the rate limiter
 	rate := limiter.Rate{
@@ -84,27 +200,61 @@ func main() {
 	// Initialize router and start server
 	mux := http.NewServeMux()
 
-	// API routes
+	// API routes. Everything except the index and health check requires an API key
+	// with the matching scope (or the admin credentials) when auth is enabled.
+	username, password := ko.String("admin.username"), ko.String("admin.password")
+	keyAuth := middleware.NewAPIKeyAuth(app.lookupAPIKey, username, password)
+	protect := func(scope string, h http.HandlerFunc) http.Handler {
+		if !ko.Bool("auth.enabled") {
+			return h
+		}
+		return keyAuth.Require(scope)(h)
+	}
+
 	mux.HandleFunc("GET /api/v1", app.handleIndex)
 	mux.HandleFunc("GET /api/v1/health", app.handleHealthCheck)
-	mux.HandleFunc("POST /api/v1/shorten", app.handleShortenURL)
-	mux.HandleFunc("POST /api/v1/bulk-shorten", app.handleBulkUpload)
-	mux.HandleFunc("GET /api/v1/urls", app.handleGetURLs)
-	mux.HandleFunc("DELETE /api/v1/urls/{shortCode}", app.handleDeleteURL)
+	mux.Handle("POST /api/v1/shorten", protect(middleware.ScopeURLsCreate, app.handleShortenURL))
+	mux.Handle("POST /api/v1/bulk-shorten", protect(middleware.ScopeURLsCreate, app.handleBulkUpload))
+	mux.Handle("GET /api/v1/urls", protect(middleware.ScopeURLsRead, app.handleGetURLs))
+	mux.Handle("GET /api/v1/urls/{shortCode}/stats", protect(middleware.ScopeURLsRead, app.handleGetStats))
+	mux.Handle("GET /api/v1/urls/{shortCode}/qr", protect(middleware.ScopeURLsRead, app.handleGetQR))
+	mux.Handle("PATCH /api/v1/urls/{shortCode}", protect(middleware.ScopeURLsUpdate, app.handleUpdateURL))
+	mux.Handle("DELETE /api/v1/urls/{shortCode}", protect(middleware.ScopeURLsDelete, app.handleDeleteURL))
+	mux.Handle("POST /api/v1/keys", protect(middleware.ScopeKeysManage, app.handleCreateAPIKey))
+	mux.Handle("GET /api/v1/keys", protect(middleware.ScopeKeysManage, app.handleGetAPIKeys))
+	mux.Handle("DELETE /api/v1/keys/{id}", protect(middleware.ScopeKeysManage, app.handleRevokeAPIKey))
 	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
 		metrics.WritePrometheus(w, true)
 	})
 
 	// Admin UI routes with basic auth
 	adminHandler := getAdminUI()
-	if username, password := ko.String("admin.username"), ko.String("admin.password"); username != "" && password != "" {
+	if username != "" && password != "" {
 		adminHandler = middleware.BasicAuth(username, password)(adminHandler)
 	}
 	mux.Handle("GET /admin/", adminHandler)
 	mux.Handle("GET /admin/...", adminHandler)
 
-	// Short URL redirect handler (catch-all)
-	mux.Handle("GET /{shortCode}", middleware.RateLimiter(rate)(http.HandlerFunc(app.handleRedirect)))
+	// Short URL redirect handler (catch-all), which also serves the QR codes of
+	// links at /{shortCode}.qr
+	redirectHandler := middleware.RateLimiter(rate)(http.HandlerFunc(app.handleRedirect))
+	mux.Handle("GET /{shortCode}", redirectHandler)
+	// Links that forward the path are followed with anything after the short code
+	mux.Handle("GET /{shortCode}/{rest...}", redirectHandler)
+
+	// Password and preview page submissions. Wrong passwords are limited per
+	// client and link on top of the overall rate limit.
+	passwordRate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  ko.Int64("rate.password_limit"),
+	}
+	if passwordRate.Limit <= 0 {
+		passwordRate.Limit = 10
+	}
+	app.passwords = middleware.NewPasswordLimiter(passwordRate, app.proxies)
+	unlockHandler := middleware.RateLimiter(rate)(http.HandlerFunc(app.handleUnlock))
+	mux.Handle("POST /{shortCode}", unlockHandler)
+	mux.Handle("POST /{shortCode}/{rest...}", unlockHandler)
 
 	server := &http.Server{
 		Addr:         ko.MustString("server.address"),
@@ -115,11 +265,81 @@ func main() {
 	}
 
 	// Start URL expiry worker
-	app.store.StartExpiryWorker(context.Background())
+	app.store.StartExpiryWorker(ctx)
+
+	go func() {
+		app.logger.Info("starting server", "address", server.Addr, "build", buildString)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error("server failed to start", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	app.shutdown(server)
+}
+
+// shutdown stops accepting new connections, waits for in-flight requests, and
+// then flushes the store and drains the analytics queue.
+func (app *App) shutdown(server *http.Server) {
+	app.logger.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), durationOr("server.shutdown_timeout", 10*time.Second))
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		app.logger.Error("failed to shut down server gracefully", "error", err)
+	}
+
+	// Let the enricher finish the queued links while the store still accepts updates
+	if app.enricher != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), durationOr("enrich.drain_timeout", 5*time.Second))
+		defer cancel()
+		if err := app.enricher.Shutdown(ctx); err != nil {
+			app.logger.Error("failed to drain enrich queue", "error", err)
+		}
+	}
 
-	app.logger.Info("starting server", "address", server.Addr, "build", buildString)
-	if err := server.ListenAndServe(); err != nil {
-		app.logger.Error("server failed to start", "error", err)
+	// Flush the write buffer and click counters
+	if err := app.store.Close(); err != nil {
+		app.logger.Error("failed to close store", "error", err)
+	}
+
+	if app.analytics != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), durationOr("analytics.drain_timeout", 5*time.Second))
+		defer cancel()
+		if err := app.analytics.Shutdown(ctx); err != nil {
+			app.logger.Error("failed to drain analytics", "error", err)
+		}
+	}
+
+	if err := app.validator.Close(); err != nil {
+		app.logger.Error("failed to stop watching domain lists", "error", err)
+	}
+
+	if app.geo != nil {
+		if err := app.geo.Close(); err != nil {
+			app.logger.Error("failed to close geoip database", "error", err)
+		}
+	}
+
+	app.logger.Info("shutdown complete")
+}
+
+// migrate applies the pending database migrations, or only lists them in a
+// dry run, and exits on failure.
+func (app *App) migrate(cfg store.Conf, dryRun bool) {
+	migrations, err := store.Migrate(cfg, dryRun, app.logger)
+	if err != nil {
+		app.logger.Error("failed to migrate database", "error", err)
 		os.Exit(1)
 	}
+
+	if dryRun {
+		for _, m := range migrations {
+			app.logger.Info("pending migration", "version", m.Version, "name", m.Name)
+		}
+		app.logger.Info("dry run complete", "pending", len(migrations))
+		return
+	}
+	app.logger.Info("database is up to date", "applied", len(migrations))
 }
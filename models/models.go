@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
 
 type URLData struct {
 	URL       string     `json:"url"`
@@ -8,4 +12,213 @@ type URLData struct {
 	ShortCode string     `json:"short_code"`
 	CreatedAt time.Time  `json:"created_at"`
 	ExpiresAt *time.Time `json:"expires_at"`
+	// Description and Favicon are fetched from the target page after the
+	// link is created, along with the title if it has none.
+	Description string `json:"description,omitempty"`
+	Favicon     string `json:"favicon,omitempty"`
+	// ActiveFrom is when the link starts resolving, nil if it does right away.
+	ActiveFrom *time.Time `json:"active_from,omitempty"`
+	// RedirectType is the HTTP status of the redirect. 0 uses the server default.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// PasswordHash is the bcrypt hash of the password required to follow the
+	// link, if any. It's never exposed; see MarshalJSON.
+	PasswordHash string `json:"-"`
+	// MaxClicks limits how many times the link can be followed, 0 is unlimited.
+	// RemainingClicks counts down from it and is nil for unlimited links.
+	MaxClicks       int64  `json:"max_clicks,omitempty"`
+	RemainingClicks *int64 `json:"remaining_clicks,omitempty"`
+	// ExpiredRedirectURL is where the link redirects to once it has expired or
+	// run out of clicks. Such links are kept instead of being removed.
+	ExpiredRedirectURL string `json:"expired_redirect_url,omitempty"`
+	// Variants are weighted alternative targets for A/B tests. When set, each
+	// redirect goes to one of them instead of URL, the same one for returning
+	// visitors if StickyVariants is set.
+	Variants       []Variant `json:"variants,omitempty"`
+	StickyVariants bool      `json:"sticky_variants,omitempty"`
+	// Rules send visitors to other targets depending on their device,
+	// language or country. The first matching rule wins over URL and Variants.
+	Rules []Rule `json:"rules,omitempty"`
+	// ForwardQuery appends the query parameters of the request to the target.
+	// ForwardPath maps the path after the short code onto the target, so that
+	// /{shortCode}/a/b redirects to {target}/a/b.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	ForwardPath  bool `json:"forward_path,omitempty"`
+	// Interstitial shows every visitor a preview page with the target instead
+	// of redirecting them right away.
+	Interstitial bool `json:"interstitial,omitempty"`
+	// UTM are the campaign parameters that were added to the URL when the
+	// link was created, nil if there were none.
+	UTM *UTM `json:"utm,omitempty"`
+	// OG is the preview card served to social network crawlers, nil if the
+	// link has none.
+	OG *OpenGraph `json:"og,omitempty"`
+	// Clicks and LastClickedAt are filled in by listings.
+	Clicks        int64      `json:"clicks"`
+	LastClickedAt *time.Time `json:"last_clicked_at,omitempty"`
+}
+
+// Variant is one of the targets of an A/B link. It's picked for a share of
+// Weight out of the sum of the weights of all variants.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Rule is a conditional target of a link. It matches visitors that meet all of
+// its conditions; a condition with several values is met by any of them.
+type Rule struct {
+	// OS is matched against the operating system, e.g. "ios" or "android".
+	OS []string `json:"os,omitempty"`
+	// Devices is matched against the device type: "mobile", "tablet",
+	// "desktop" or "bot".
+	Devices []string `json:"devices,omitempty"`
+	// Languages is matched against the most preferred language of the
+	// visitor, "de" matching any regional variant like "de-AT".
+	Languages []string `json:"languages,omitempty"`
+	// Countries is matched against the ISO 3166-1 alpha-2 code of the country
+	// of the visitor's IP, e.g. "DE". It needs a geoip database.
+	Countries []string `json:"countries,omitempty"`
+	URL       string   `json:"url"`
+}
+
+// Variant returns the variant with the given name.
+func (u URLData) Variant(name string) (Variant, bool) {
+	for _, v := range u.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Expired reports whether the URL is past its expiry or has no clicks left.
+func (u URLData) Expired() bool {
+	if u.ExpiresAt != nil && time.Now().After(*u.ExpiresAt) {
+		return true
+	}
+	return u.RemainingClicks != nil && *u.RemainingClicks <= 0
+}
+
+// Scheduled reports whether the URL isn't active yet.
+func (u URLData) Scheduled() bool {
+	return u.ActiveFrom != nil && time.Now().Before(*u.ActiveFrom)
+}
+
+// MarshalJSON adds whether the URL is password protected.
+func (u URLData) MarshalJSON() ([]byte, error) {
+	type urlData URLData
+	return json.Marshal(struct {
+		urlData
+		PasswordProtected bool `json:"password_protected,omitempty"`
+	}{urlData(u), u.PasswordHash != ""})
+}
+
+// ValidRedirectType reports whether code is a redirect status a link can use.
+func ValidRedirectType(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// Sort keys accepted by URLQuery.
+const (
+	SortCreatedAt = "created_at"
+	SortClicks    = "clicks"
+	SortTitle     = "title"
+)
+
+// URLQuery filters and orders a listing of URLs. Results are paginated either
+// by page number or, when Keyset is set, by an opaque cursor.
+type URLQuery struct {
+	Page    int64
+	PerPage int64
+
+	// Keyset switches to cursor pagination. Cursor is the next_cursor of the
+	// previous page, empty for the first page.
+	Keyset bool
+	Cursor string
+	// WithCount includes the total number of matches. Always set in page mode.
+	WithCount bool
+
+	// Search is a free-text search over the short code, URL and title.
+	Search string
+	// Expired lists expired URLs instead of live ones.
+	Expired bool
+	// Scheduled lists URLs that aren't active yet instead of live ones.
+	Scheduled bool
+	// WithVariants only lists A/B links.
+	WithVariants bool
+	// Campaign only lists links created with this utm_campaign.
+	Campaign       string
+	ExpiringBefore *time.Time
+	CreatedAfter   *time.Time
+
+	// Sort is one of the Sort* keys, defaults to SortCreatedAt.
+	Sort string
+	Desc bool
+}
+
+// URLPage is a page of a URL listing.
+type URLPage struct {
+	URLs []URLData
+	// Total is only set when URLQuery.WithCount is.
+	Total *int64
+	// NextCursor is set in keyset mode when there are more results.
+	NextCursor string
+}
+
+// URLStats holds the click statistics of a short URL.
+type URLStats struct {
+	ShortCode     string        `json:"short_code"`
+	Clicks        int64         `json:"clicks"`
+	LastClickedAt *time.Time    `json:"last_clicked_at,omitempty"`
+	Daily         []DailyClicks `json:"daily"`
+}
+
+// DailyClicks is the number of clicks on a single (UTC) day.
+type DailyClicks struct {
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+// URLUpdate describes a partial update to an existing URL. Nil fields are left
+// untouched; ClearExpiry and ClearActiveFrom remove any existing expiry and
+// activation time.
+type URLUpdate struct {
+	URL             *string
+	Title           *string
+	Description     *string
+	Favicon         *string
+	ExpiresAt       *time.Time
+	ClearExpiry     bool
+	ActiveFrom      *time.Time
+	ClearActiveFrom bool
+	// RedirectType of 0 reverts to the server default.
+	RedirectType *int
+	// PasswordHash of "" removes the password.
+	PasswordHash *string
+	// MaxClicks resets the click limit and the remaining clicks; 0 removes the limit.
+	MaxClicks *int64
+	// ExpiredRedirectURL of "" removes the fallback of the expired link.
+	ExpiredRedirectURL *string
+	// Variants replaces the variants, an empty slice removes them.
+	Variants       *[]Variant
+	StickyVariants *bool
+	// Rules replaces the targeting rules, an empty slice removes them.
+	Rules        *[]Rule
+	ForwardQuery *bool
+	ForwardPath  *bool
+	Interstitial *bool
+	// OG replaces the preview card, an empty one removes it.
+	OG *OpenGraph
+}
+
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
 }
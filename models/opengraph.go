@@ -0,0 +1,33 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+)
+
+// OpenGraph is the preview card of a link, shown when it's shared on social
+// networks and in chats instead of the card of its target.
+type OpenGraph struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Image is the absolute URL of the card image.
+	Image string `json:"image,omitempty"`
+}
+
+// IsZero reports whether none of the fields are set.
+func (o OpenGraph) IsZero() bool {
+	return o == OpenGraph{}
+}
+
+// Validate checks that the image, if any, is an absolute http(s) URL, as
+// crawlers don't resolve relative ones.
+func (o OpenGraph) Validate() error {
+	if o.Image == "" {
+		return nil
+	}
+	u, err := url.Parse(o.Image)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("og image must be an absolute http or https URL")
+	}
+	return nil
+}
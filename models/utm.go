@@ -0,0 +1,77 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UTM holds the campaign parameters a link was created with.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// IsZero reports whether none of the parameters are set.
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+// params returns the query parameters of the set fields, in the usual order.
+func (u UTM) params() [][2]string {
+	var out [][2]string
+	for _, p := range [][2]string{
+		{"utm_source", u.Source},
+		{"utm_medium", u.Medium},
+		{"utm_campaign", u.Campaign},
+		{"utm_term", u.Term},
+		{"utm_content", u.Content},
+	} {
+		if p[1] != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// AddTo adds the parameters to the query of a URL, replacing any it already
+// has of the same name. Other query parameters are kept as they are, as is
+// the fragment.
+func (u UTM) AddTo(target string) (string, error) {
+	params := u.params()
+	if len(params) == 0 {
+		return target, nil
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	set := make(map[string]bool, len(params))
+	for _, p := range params {
+		set[p[0]] = true
+	}
+
+	// Rebuild the query by hand rather than with url.Values, which would
+	// reorder and re-encode the existing parameters.
+	var parts []string
+	if parsed.RawQuery != "" {
+		for _, part := range strings.Split(parsed.RawQuery, "&") {
+			key, _, _ := strings.Cut(part, "=")
+			if k, err := url.QueryUnescape(key); err == nil && set[k] {
+				continue
+			}
+			parts = append(parts, part)
+		}
+	}
+	for _, p := range params {
+		parts = append(parts, p[0]+"="+url.QueryEscape(p[1]))
+	}
+
+	parsed.RawQuery = strings.Join(parts, "&")
+	parsed.ForceQuery = false
+	return parsed.String(), nil
+}
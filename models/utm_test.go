@@ -0,0 +1,36 @@
+package models
+
+import "testing"
+
+func TestUTMAddTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		utm     UTM
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{"none", UTM{}, "https://example.com/?a=1", "https://example.com/?a=1", false},
+		{"no query", UTM{Source: "nl"}, "https://example.com/page", "https://example.com/page?utm_source=nl", false},
+		{"order", UTM{Content: "c", Source: "s", Campaign: "x"}, "https://example.com/", "https://example.com/?utm_source=s&utm_campaign=x&utm_content=c", false},
+		{"existing query kept", UTM{Medium: "email"}, "https://example.com/?b=2&a=%2F", "https://example.com/?b=2&a=%2F&utm_medium=email", false},
+		{"replaces same name", UTM{Source: "new"}, "https://example.com/?utm_source=old&utm_medium=web", "https://example.com/?utm_medium=web&utm_source=new", false},
+		{"replaces escaped name", UTM{Source: "new"}, "https://example.com/?utm%5Fsource=old", "https://example.com/?utm_source=new", false},
+		{"fragment kept", UTM{Term: "shoes"}, "https://example.com/p#top", "https://example.com/p?utm_term=shoes#top", false},
+		{"empty query", UTM{Source: "nl"}, "https://example.com/?", "https://example.com/?utm_source=nl", false},
+		{"escaped value", UTM{Campaign: "spring sale&more"}, "https://example.com/", "https://example.com/?utm_campaign=spring+sale%26more", false},
+		{"invalid url", UTM{Source: "nl"}, "https://exa mple.com/", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.utm.AddTo(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddTo(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("AddTo(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
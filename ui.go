@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"html/template"
 	"io"
 	"io/fs"
 	"net/http"
@@ -10,6 +11,12 @@ import (
 //go:embed ui/dist
 var uiFiles embed.FS
 
+//go:embed templates
+var templateFiles embed.FS
+
+// pages are the HTML pages served on the public short link routes.
+var pages = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
 // getAdminUI returns a http.Handler that serves the admin UI from embedded static files.
 // It handles both static file serving and SPA routing by:
 // 1. Attempting to serve static files directly from the embedded filesystem